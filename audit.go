@@ -0,0 +1,179 @@
+package dsync
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DEFAULT_AUDIT_TABLE_NAME = "dsync_run_audit"
+
+// RunAudit Summary of a single Migrate run
+type RunAudit struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+	// AppliedBy user@host of the process running the migrations
+	AppliedBy string
+	// Applied The migrations committed during the run
+	Applied []Migration
+	Success bool
+	// Error The error returned by Migrate, if any
+	Error string
+}
+
+// AuditSink Persists run audits. Unlike the migration table, which records individual migrations, an audit
+// sink records every run including failed runs and runs that had nothing to apply.
+type AuditSink interface {
+	RecordRun(audit RunAudit) error
+}
+
+// NopAuditSink Discards every run audit
+type NopAuditSink struct{}
+
+func (NopAuditSink) RecordRun(RunAudit) error {
+	return nil
+}
+
+// SQLAuditSink Appends run audits to an append-only table, created on first use. The table definition only
+// uses types common to sqlite, postgresql and mysql, unless the dialect is an AuditTableCreator.
+type SQLAuditSink struct {
+	DB *sql.DB
+	// TableName Defaults to DEFAULT_AUDIT_TABLE_NAME. Like the migration table, only letters, digits and underscores
+	// are allowed, optionally qualified as schema.table.
+	TableName string
+	// NumberedPlaceholders Use $1, $2, ... placeholders (postgresql) instead of ?. Ignored when Dialect is set.
+	NumberedPlaceholders bool
+	// Dialect Quotes the table name, numbers the placeholders and looks the table up before creating it, see
+	// NewSQLAuditSink. Without it the table name is used as is and the table is created with CREATE TABLE IF NOT
+	// EXISTS, which SQL Server does not support.
+	Dialect Dialect
+}
+
+// AuditTableCreator Optionally implemented by dialects whose types differ from those of the default audit table
+type AuditTableCreator interface {
+	// CreateAuditTableStatement Returns the statement creating the audit table, given its quoted name
+	CreateAuditTableStatement(table string) string
+}
+
+// NewSQLAuditSink Create a SQLAuditSink writing to table (DEFAULT_AUDIT_TABLE_NAME when empty) through the handle
+// and dialect of a data source created with NewSQLSource, as the bundled SQL sources are
+func NewSQLAuditSink(ds DataSource, table string) (SQLAuditSink, error) {
+	source, ok := ds.(interface{ Dialect() Dialect })
+	if !ok || ds.Handle() == nil {
+		return SQLAuditSink{}, errors.New("data source is not a SQL data source")
+	}
+	return SQLAuditSink{DB: ds.Handle(), TableName: table, Dialect: source.Dialect()}, nil
+}
+
+// audit_table_columns Columns of the default audit table
+const audit_table_columns = ` (StartedAt TIMESTAMP NOT NULL
+		, FinishedAt TIMESTAMP NOT NULL
+		, DurationMs BIGINT NOT NULL
+		, AppliedBy VARCHAR(255) NOT NULL
+		, Migrations TEXT NOT NULL
+		, Success BOOLEAN NOT NULL
+		, Error TEXT)`
+
+func (s SQLAuditSink) RecordRun(audit RunAudit) error {
+	table := s.TableName
+	if len(strings.TrimSpace(table)) == 0 {
+		table = DEFAULT_AUDIT_TABLE_NAME
+	}
+	if !table_name_pattern.MatchString(table) {
+		return errors.Errorf("invalid audit table name %q: only letters, digits and underscores are allowed, optionally qualified as schema.table", table)
+	}
+
+	quoted, err := s.createTable(table)
+	if err != nil {
+		return errors.Wrap(err, "failed to create audit table")
+	}
+
+	var sb strings.Builder
+	var files []string
+	for _, m := range audit.Applied {
+		files = append(files, m.File)
+	}
+
+	sb.WriteString(`INSERT INTO `)
+	sb.WriteString(quoted)
+	sb.WriteString(`(StartedAt, FinishedAt, DurationMs, AppliedBy, Migrations, Success, Error) VALUES (`)
+	for i := 1; i <= 7; i++ {
+		if i > 1 {
+			sb.WriteString(", ")
+		}
+		if s.Dialect != nil {
+			sb.WriteString(s.Dialect.Placeholder(i))
+		} else if s.NumberedPlaceholders {
+			sb.WriteString("$" + strconv.Itoa(i))
+		} else {
+			sb.WriteString("?")
+		}
+	}
+	sb.WriteString(`)`)
+
+	_, err = s.DB.Exec(sb.String(),
+		audit.StartedAt,
+		audit.FinishedAt,
+		audit.Duration.Milliseconds(),
+		audit.AppliedBy,
+		strings.Join(files, ","),
+		audit.Success,
+		sql.NullString{String: audit.Error, Valid: len(audit.Error) > 0},
+	)
+	return errors.Wrap(err, "failed to record run audit")
+}
+
+// createTable Create the audit table unless it exists, returning its quoted name
+func (s SQLAuditSink) createTable(table string) (string, error) {
+	if s.Dialect == nil {
+		_, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS ` + table + audit_table_columns)
+		return table, err
+	}
+
+	quoted := s.Dialect.QuoteIdentifier(table)
+	if quoter, ok := s.Dialect.(TableQuoter); ok {
+		quoted = quoter.QuoteTable(table)
+	} else if strings.Contains(table, ".") {
+		return "", errors.Errorf("invalid audit table name %q: the database does not support schema qualified table names", table)
+	}
+
+	exists, err := s.tableExists(table)
+	if err != nil || exists {
+		return quoted, err
+	}
+	create := `CREATE TABLE ` + quoted + audit_table_columns
+	if creator, ok := s.Dialect.(AuditTableCreator); ok {
+		create = creator.CreateAuditTableStatement(quoted)
+	}
+	if _, err := s.DB.Exec(create); err != nil {
+		// created concurrently by another run
+		if exists, _ := s.tableExists(table); exists {
+			return quoted, nil
+		}
+		return "", err
+	}
+	return quoted, nil
+}
+
+// tableExists Look the audit table up with the TableExistsQuery of the dialect
+func (s SQLAuditSink) tableExists(table string) (bool, error) {
+	query, args := s.Dialect.TableExistsQuery(table)
+	var exists bool
+	err := s.DB.QueryRow(query, args...).Scan(&exists)
+	return exists, err
+}
+
+// defaultAppliedBy user@host of the current process
+func defaultAppliedBy() string {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = os.Getenv("USERNAME")
+	}
+	host, _ := os.Hostname()
+	return user + "@" + host
+}
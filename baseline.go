@@ -0,0 +1,80 @@
+package dsync
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// MigrationRecorder is implemented by data sources that can record a migration as applied without executing it
+type MigrationRecorder interface {
+	// RecordMigrationContext Insert a successful record of the migration, leaving its script unexecuted
+	RecordMigrationContext(ctx context.Context, m *Migration) error
+}
+
+// Baseline Record the versioned migration files up to version (all of them when zero) as applied without executing
+// them, in a single transaction, to adopt a database whose schema already matches them. Later files are applied by
+// the next run as usual, and so are the repeatable migrations. Refuses to run once any migration has been recorded.
+// Returns the recorded migrations.
+func (migrator Migrator) Baseline(ds DataSource, version int64) ([]*Migration, error) {
+	var recorded []*Migration
+
+	recorder, ok := ds.(MigrationRecorder)
+	if !ok {
+		return nil, errors.New("data source does not support baselines")
+	}
+
+	unlock, err := migrator.lock(context.Background(), ds)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	info, err := ds.GetMigrationInfo()
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Migrations) > 0 {
+		return nil, errors.Errorf("cannot baseline, %d migration(s) already recorded in %s", len(info.Migrations), info.TableName)
+	}
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadDataSourceMigrations(cfs, ds, migrator.loadOptions())
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && !hasVersion(migrations, version) {
+		return nil, errors.Errorf("baseline version %d does not correspond to any migration file", version)
+	}
+
+	tx := transaction{ds: ds}
+	defer tx.rollback()
+
+	if err := tx.begin(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "baseline failed.")
+	}
+	for i := range migrations {
+		m := &migrations[i]
+		if m.Repeatable || (version != 0 && m.Version > version) {
+			continue
+		}
+		m.CreatedAt = migrator.now()
+		m.AppliedBy = migrator.appliedBy()
+		if err := recorder.RecordMigrationContext(context.Background(), m); err != nil {
+			return nil, errors.Wrap(err, "baseline failed")
+		}
+		recorded = append(recorded, m)
+	}
+	if err := tx.commit(); err != nil {
+		return nil, errors.Wrap(err, "baseline failed")
+	}
+
+	for _, m := range recorded {
+		migrator.logf("dsync: baselined %s (version %d)", m.File, m.Version)
+	}
+	return recorded, nil
+}
@@ -0,0 +1,108 @@
+package dsync
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Blob Binary data inserted by a migration from a companion file, declared with
+// "-- dsync:blob table=<table> column=<column> file=<file>". The file is read from the directory of the migration
+// and inserted as a parameter, once the script has run, so that binary data never has to be escaped into SQL.
+type Blob struct {
+	// Table Table the data is inserted into, optionally qualified as schema.table
+	Table string
+	// Column Column receiving the data
+	Column string
+	// File Path of the data file relative to the directory of the migration
+	File string
+}
+
+// column_name_pattern Column names accepted by the blob directive
+var column_name_pattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// parseBlob Parse the value of a blob directive
+func parseBlob(m *Migration, value string) (Blob, error) {
+	var blob Blob
+
+	for _, field := range strings.Fields(value) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return blob, errors.Errorf("%s: invalid blob directive argument %q, expected key=value", m.File, field)
+		}
+		switch strings.ToLower(key) {
+		case "table":
+			blob.Table = value
+		case "column":
+			blob.Column = value
+		case "file":
+			blob.File = value
+		default:
+			return blob, errors.Errorf("%s: unknown blob directive argument %s", m.File, key)
+		}
+	}
+
+	switch {
+	case blob.Table == "" || blob.Column == "" || blob.File == "":
+		return blob, errors.Errorf("%s: the blob directive requires table, column and file", m.File)
+	case !table_name_pattern.MatchString(blob.Table):
+		return blob, errors.Errorf("%s: invalid blob table name %q", m.File, blob.Table)
+	case !column_name_pattern.MatchString(blob.Column):
+		return blob, errors.Errorf("%s: invalid blob column name %q", m.File, blob.Column)
+	case !fs.ValidPath(blob.File):
+		return blob, errors.Errorf("%s: invalid blob file %q, it must be relative to the migration directory", m.File, blob.File)
+	}
+	return blob, nil
+}
+
+// BlobPath Path of a blob file within the change set file system, basepath being the data source's GetPath
+func BlobPath(basepath string, m *Migration, blob Blob) string {
+	return path.Join(basepath, path.Dir(m.File), blob.File)
+}
+
+// ReadBlob Read the data of a blob declared by a migration, basepath being the data source's GetPath
+func ReadBlob(fsys fs.FS, basepath string, m *Migration, blob Blob) ([]byte, error) {
+	data, err := fs.ReadFile(fsys, BlobPath(basepath, m, blob))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read blob file")
+	}
+	return data, nil
+}
+
+// QuoteQualifiedIdentifier Quote each dot separated part of an identifier with QuoteIdentifier, e.g. schema.table
+func QuoteQualifiedIdentifier(name string, open string, close string) string {
+	parts := strings.Split(name, ".")
+	for i := range parts {
+		parts[i] = QuoteIdentifier(parts[i], open, close)
+	}
+	return strings.Join(parts, ".")
+}
+
+// hashBlobs Write the content of the blob files to w, as they are, after the script they belong to
+func hashBlobs(fsys fs.FS, blobs []string, w io.Writer) error {
+	for _, name := range blobs {
+		file, err := fsys.Open(name)
+		if err != nil {
+			return errors.Wrap(err, "failed to read blob file")
+		}
+		_, err = io.Copy(w, file)
+		file.Close()
+		if err != nil {
+			return errors.Wrap(err, "failed to read blob file")
+		}
+	}
+	return nil
+}
+
+// blobPaths Paths of the blob files of a migration within fsys
+func blobPaths(basepath string, m *Migration) []string {
+	var paths []string
+	for _, blob := range m.Blobs {
+		paths = append(paths, BlobPath(basepath, m, blob))
+	}
+	return paths
+}
@@ -0,0 +1,266 @@
+package dsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumAlgorithm Algorithm used to detect changes to applied migration files
+type ChecksumAlgorithm int
+
+const (
+	// CRC32 Only the CRC32 checksum (Migration.Checksum) is computed and verified
+	CRC32 ChecksumAlgorithm = iota
+	// SHA256 A SHA-256 digest (Migration.Digest) is recorded alongside the CRC32 checksum. Migrations recorded
+	// without a digest keep being verified against their CRC32 checksum.
+	SHA256
+)
+
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case CRC32:
+		return "crc32"
+	case SHA256:
+		return "sha256"
+	default:
+		return "unknown"
+	}
+}
+
+// ChecksumMode What part of a migration file is hashed
+type ChecksumMode int
+
+const (
+	// Raw Every byte of the file is hashed
+	Raw ChecksumMode = iota
+	// Normalized The file is hashed after NormalizeSQL, so that editing comments or reformatting whitespace does
+	// not change its checksum
+	Normalized
+)
+
+func (m ChecksumMode) String() string {
+	switch m {
+	case Raw:
+		return "raw"
+	case Normalized:
+		return "normalized"
+	default:
+		return "unknown"
+	}
+}
+
+// tight_punctuation Characters NormalizeSQL drops the whitespace around
+const tight_punctuation = "(),;"
+
+// NormalizeSQL Canonical form of a script hashed by the Normalized checksum mode. It is a lexical pass, not a SQL
+// parser:
+//
+//   - "--" and, possibly nested, "/* */" comments are removed, except "-- dsync:" directives which change how the
+//     script is applied and are kept as "-- dsync:...", on their own line
+//   - every run of whitespace and comments is replaced by a single space, or dropped at the start and end of the
+//     script and next to parentheses, commas and semicolons
+//   - string literals, quoted identifiers and dollar quoted bodies are kept byte for byte
+//
+// Statements, keywords and identifier case are otherwise left as written.
+func NormalizeSQL(sql string) string {
+	var b strings.Builder
+
+	space := false
+	write := func(token string) {
+		if space && b.Len() > 0 {
+			last := b.String()[b.Len()-1]
+			if last != '\n' && !strings.ContainsRune(tight_punctuation, rune(last)) && !strings.ContainsRune(tight_punctuation, rune(token[0])) {
+				b.WriteByte(' ')
+			}
+		}
+		space = false
+		b.WriteString(token)
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := skipLineComment(sql, i)
+			comment := strings.TrimSpace(sql[i+2 : end])
+			if strings.HasPrefix(comment, directive_prefix) {
+				if b.Len() > 0 {
+					b.WriteByte('\n')
+				}
+				b.WriteString("-- " + comment + "\n")
+				space = false
+			} else {
+				space = true
+			}
+			i = end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+			space = true
+		case c == '\'' || c == '"' || c == '`':
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i == 1 || !isWordChar(sql[i-2]))
+			end := skipQuoted(sql, i, escapes)
+			write(sql[i:end])
+			i = end
+		case c == '$' && (i == 0 || !isWordChar(sql[i-1])) && dollarTag(sql[i:]) != "":
+			end := skipDollarQuoted(sql, i)
+			write(sql[i:end])
+			i = end
+		case isSpace(c):
+			space = true
+			i++
+		default:
+			write(sql[i : i+1])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// DigestFile Calculate the algorithm prefixed digest ("sha256:<hex>") of a, decompressed, file. CRC32 has no digest, the
+// checksum computed by HashFile is used instead, and an empty string is returned.
+func DigestFile(_fs fs.FS, filename string, algorithm ChecksumAlgorithm) (string, error) {
+	if algorithm != SHA256 {
+		return "", nil
+	}
+
+	_, digest, _, err := checksums(_fs, filename, nil, LoadOptions{ChecksumAlgorithm: algorithm})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to calculate file digest")
+	}
+	return digest, nil
+}
+
+// NormalizeLineEndings Remove every carriage return so that files checked out with CRLF and LF line endings
+// hash the same
+func NormalizeLineEndings(content []byte) []byte {
+	return bytes.ReplaceAll(content, []byte("\r"), nil)
+}
+
+// lineEndingNormalizer Apply NormalizeLineEndings to the content of a reader
+type lineEndingNormalizer struct {
+	r io.Reader
+}
+
+func (n lineEndingNormalizer) Read(p []byte) (int, error) {
+	for {
+		l, err := n.r.Read(p)
+		l = copy(p, NormalizeLineEndings(p[:l]))
+		if l > 0 || err != nil {
+			return l, err
+		}
+	}
+}
+
+// checksums Compute the checksum, the number of bytes hashed and, depending on the options, the digest of a migration
+// file followed by its blob files. Compressed files are hashed decompressed. The file is streamed rather than read in
+// memory at once, unless it is normalized.
+func checksums(fsys fs.FS, filename string, blobs []string, opts LoadOptions) (int64, string, int64, error) {
+	file, err := OpenMigrationFile(fsys, filename)
+	if err != nil {
+		return 0, "", 0, errors.Wrap(err, "failed to calculate file hash")
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if opts.NormalizeLineEndings {
+		r = lineEndingNormalizer{r: r}
+	}
+	if opts.ChecksumMode == Normalized {
+		script, err := io.ReadAll(r)
+		if err != nil {
+			return 0, "", 0, errors.Wrap(err, "failed to calculate file hash")
+		}
+		r = strings.NewReader(NormalizeSQL(string(script)))
+	}
+
+	crc := crc32.NewIEEE()
+	var sha hash.Hash
+	var size byteCounter
+	var w io.Writer = io.MultiWriter(crc, &size)
+	if opts.ChecksumAlgorithm == SHA256 {
+		sha = sha256.New()
+		w = io.MultiWriter(crc, sha, &size)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return 0, "", 0, errors.Wrap(err, "failed to calculate file hash")
+	}
+	if err := hashBlobs(fsys, blobs, w); err != nil {
+		return 0, "", 0, errors.Wrap(err, "failed to calculate file hash")
+	}
+
+	if sha == nil {
+		return int64(crc.Sum32()), "", int64(size), nil
+	}
+	return int64(crc.Sum32()), opts.ChecksumAlgorithm.String() + ":" + hex.EncodeToString(sha.Sum(nil)), int64(size), nil
+}
+
+// byteCounter Writer counting the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// ParseChecksumAlgorithm Parse the name of a checksum algorithm as returned by ChecksumAlgorithm.String. An empty
+// name, recorded before the algorithm was, is CRC32.
+func ParseChecksumAlgorithm(name string) (ChecksumAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "crc32":
+		return CRC32, nil
+	case "sha256":
+		return SHA256, nil
+	}
+	return CRC32, errors.Errorf("unknown checksum algorithm %q", name)
+}
+
+// recordedAlgorithm Algorithm an applied migration was recorded with. Rows recorded before the algorithm was
+// have a digest when recorded with SHA256.
+func recordedAlgorithm(applied *Migration) ChecksumAlgorithm {
+	if applied.Algorithm == SHA256 || applied.Digest != "" {
+		return SHA256
+	}
+	return CRC32
+}
+
+// checksumMatches Compare the digests when the applied migration was recorded with SHA256, the CRC32 checksums
+// otherwise. The file digest is computed by digestApplied when the configured algorithm does not.
+func checksumMatches(file *Migration, applied *Migration) bool {
+	if recordedAlgorithm(applied) == SHA256 && file.Digest != "" {
+		return file.Digest == applied.Digest
+	}
+	return file.Checksum == applied.Checksum
+}
+
+// digestApplied Compute the digest of the files whose migration was recorded with SHA256, when the configured
+// algorithm did not, so that they are verified with the algorithm of their record
+func digestApplied(fsys fs.FS, basepath string, migrations []Migration, applied []Migration, opts LoadOptions) error {
+	opts.ChecksumAlgorithm = SHA256
+	for i := range migrations {
+		m := &migrations[i]
+		if m.Digest != "" {
+			continue
+		}
+		for j := range applied {
+			if !sameFile(m.File, applied[j].File) || recordedAlgorithm(&applied[j]) != SHA256 {
+				continue
+			}
+			_, digest, _, err := checksums(fsys, filepath.Join(basepath, m.File), blobPaths(basepath, m), opts)
+			if err != nil {
+				return errors.Wrap(err, m.File)
+			}
+			m.Digest = digest
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Command dsync Applies and inspects the migrations of a directory from the command line, e.g. in CI/CD pipelines.
+//
+//	dsync <migrate|status|validate|verify-applied|repair|baseline|lock-status|force-unlock> --driver <driver> --dsn <dsn> [flags]
+//	dsync create --name <name> [--path <dir>]
+//
+// The exit status is 0 on success, 1 when the command fails and 2 on usage errors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/SharkFourSix/dsync"
+	"github.com/SharkFourSix/dsync/sources/mssql"
+	"github.com/SharkFourSix/dsync/sources/mysql"
+	"github.com/SharkFourSix/dsync/sources/postgresql"
+	"github.com/SharkFourSix/dsync/sources/sqlite"
+)
+
+const usage = `usage: dsync <command> --driver <driver> --dsn <dsn> [flags]
+       dsync create --name <name> [--path <dir>]
+
+commands:
+  migrate         apply the pending migrations
+  status          list the applied and pending migrations
+  validate        verify the migration files against the applied migrations
+  verify-applied  verify the checksums of the applied migration files only
+  repair          update the recorded checksums of modified migration files
+  baseline        record the migrations up to --version as applied without running them
+  lock-status     report whether the migration lock is held, and by whom
+  force-unlock    release the migration lock whoever holds it, after a crashed run
+  create          add an empty migration file named after --name, numbered after the existing files
+
+drivers: postgres, mysql, sqlite, mssql
+
+flags default to the DSYNC_* environment variables named below.
+
+flags:
+`
+
+// sources Data source constructors by driver name
+var sources = map[string]func(dsn string, cfg *dsync.Config) (dsync.DataSource, error){
+	"postgres":   postgresql.New,
+	"postgresql": postgresql.New,
+	"mysql":      mysql.New,
+	"sqlite":     sqlite.New,
+	"sqlite3":    sqlite.New,
+	"mssql":      mssql.New,
+	"sqlserver":  mssql.New,
+}
+
+// env Environment variables read for the flags not given on the command line
+var env = map[string]string{
+	"driver":       dsync.EnvDriver,
+	"dsn":          dsync.EnvDSN,
+	"path":         dsync.EnvPath,
+	"table":        dsync.EnvTable,
+	"out-of-order": dsync.EnvOutOfOrder,
+}
+
+// setFromEnv Set the flags not given on the command line from their environment variable. Values are not used as
+// flag defaults, which would print the DSN with the usage.
+func setFromEnv(flags *flag.FlagSet) error {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, variable := range env {
+		if value := os.Getenv(variable); value != "" && !given[name] {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("invalid %s %q: %v", variable, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run Execute the command line args, returning the exit status
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dsync", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	driver := flags.String("driver", "", "database driver (env "+dsync.EnvDriver+")")
+	dsn := flags.String("dsn", "", "data source name of the database (env "+dsync.EnvDSN+")")
+	dir := flags.String("path", dsync.DEFAULT_ENV_PATH, "directory of the migration files (env "+dsync.EnvPath+")")
+	table := flags.String("table", "", "name of the migration table (default \""+dsync.DEFAULT_TABLE_NAME+"\", env "+dsync.EnvTable+")")
+	outOfOrder := flags.Bool("out-of-order", false, "apply new migrations whose version is behind the current version (env "+dsync.EnvOutOfOrder+")")
+	version := flags.Int64("version", 0, "last version recorded by baseline, all of them when zero")
+	quiet := flags.Bool("quiet", false, "only print errors")
+	lockTable := flags.Bool("lock-table", false, "use the lock table rather than the database's session lock")
+	name := flags.String("name", "", "name of the migration file added by create, e.g. add_users")
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flags.Usage()
+		return 2
+	}
+	command := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if err := setFromEnv(flags); err != nil {
+		fmt.Fprintf(stderr, "dsync: %v\n", err)
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "dsync: unexpected argument %q\n", flags.Arg(0))
+		return 2
+	}
+
+	// create only needs the directory
+	if command == "create" {
+		if *name == "" {
+			fmt.Fprintln(stderr, "dsync: missing --name")
+			return 2
+		}
+		if err := create(*dir, *name, stdout); err != nil {
+			fmt.Fprintf(stderr, "dsync: create failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	newSource, ok := sources[strings.ToLower(*driver)]
+	if !ok {
+		fmt.Fprintf(stderr, "dsync: unsupported driver %q\n", *driver)
+		return 2
+	}
+	if *dsn == "" {
+		fmt.Fprintln(stderr, "dsync: missing --dsn")
+		return 2
+	}
+
+	fsys, basepath := dsync.DirFS(*dir)
+	ds, err := newSource(*dsn, &dsync.Config{FileSystem: fsys, Basepath: basepath, TableName: *table})
+	if err != nil {
+		fmt.Fprintf(stderr, "dsync: %v\n", err)
+		return 1
+	}
+	if closer, ok := ds.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	migrator := dsync.Migrator{OutOfOrder: *outOfOrder, UseLockTable: *lockTable}
+	if !*quiet {
+		migrator.Logger = log.New(stderr, "", 0)
+	}
+
+	switch command {
+	case "migrate":
+		err = migrate(migrator, ds, stdout)
+	case "status":
+		err = status(migrator, ds, stdout)
+	case "validate":
+		err = migrator.Validate(ds)
+	case "verify-applied":
+		err = migrator.VerifyApplied(ds)
+	case "repair":
+		_, err = migrator.Repair(ds)
+	case "baseline":
+		err = baseline(migrator, ds, *version, stdout)
+	case "lock-status":
+		err = lockStatus(migrator, ds, stdout)
+	case "force-unlock":
+		err = migrator.ForceUnlock(ds)
+	default:
+		fmt.Fprintf(stderr, "dsync: unknown command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "dsync: %s failed: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+// create Add an empty migration file to dir, creating dir when it does not exist, and print its path
+func create(dir string, name string, w io.Writer) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	filename, err := dsync.NewMigrationFilename(entries, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file := filepath.Join(dir, filename)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, file)
+	return nil
+}
+
+func migrate(migrator dsync.Migrator, ds dsync.DataSource, w io.Writer) error {
+	applied, err := migrator.MigrateResult(ds)
+	for _, m := range applied {
+		fmt.Fprintf(w, "applied %s\n", m.File)
+	}
+	return err
+}
+
+func status(migrator dsync.Migrator, ds dsync.DataSource, w io.Writer) error {
+	statuses, err := migrator.Status(ds)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tFILE\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		version, appliedAt := fmt.Sprint(s.Version), ""
+		if s.Repeatable {
+			version = "R"
+		}
+		if !s.AppliedAt.IsZero() {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", version, s.File, s.State, appliedAt)
+	}
+	return tw.Flush()
+}
+
+func baseline(migrator dsync.Migrator, ds dsync.DataSource, version int64, w io.Writer) error {
+	recorded, err := migrator.Baseline(ds, version)
+	if err != nil {
+		return err
+	}
+	for _, m := range recorded {
+		fmt.Fprintf(w, "baselined %s\n", m.File)
+	}
+	return nil
+}
+
+func lockStatus(migrator dsync.Migrator, ds dsync.DataSource, w io.Writer) error {
+	status, err := migrator.LockStatus(ds)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !status.Held:
+		fmt.Fprintln(w, "not locked")
+	case status.LockedAt.IsZero():
+		fmt.Fprintf(w, "locked by %s\n", status.Owner)
+	default:
+		fmt.Fprintf(w, "locked by %s since %s\n", status.Owner, status.LockedAt.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package dsync
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MigrationColumns Names of the columns of the migration table, see Config.Columns
+type MigrationColumns struct {
+	Id           string
+	Name         string
+	File         string
+	Version      string
+	CreatedAt    string
+	Checksum     string
+	VersionLabel string
+	Digest       string
+	Success      string
+	DurationMs   string
+	Algorithm    string
+	AppliedBy    string
+	Size         string
+	NoOp         string
+}
+
+// DefaultMigrationColumns Names of the columns of the migration table unless configured otherwise
+var DefaultMigrationColumns = MigrationColumns{
+	Id:           "Id",
+	Name:         "Name",
+	File:         "File",
+	Version:      "Version",
+	CreatedAt:    "CreatedAt",
+	Checksum:     "Checksum",
+	VersionLabel: "VersionLabel",
+	Digest:       "Digest",
+	Success:      "Success",
+	DurationMs:   "DurationMs",
+	Algorithm:    "Algorithm",
+	AppliedBy:    "AppliedBy",
+	Size:         "Size",
+	NoOp:         "NoOp",
+}
+
+// Names Returns the names of the columns in the order of MigrationTableColumns, the order data sources select them in
+func (c MigrationColumns) Names() []string {
+	return []string{
+		c.Id, c.Name, c.File, c.Version, c.CreatedAt, c.Checksum, c.VersionLabel, c.Digest, c.Success, c.DurationMs, c.Algorithm,
+		c.AppliedBy, c.Size, c.NoOp,
+	}
+}
+
+// Column Returns the name of the column whose default name is name (e.g. "VersionLabel"), name itself if it is not
+// a column of the migration table
+func (c MigrationColumns) Column(name string) string {
+	names := c.Names()
+	for i, column := range MigrationTableColumns {
+		if column == name {
+			return names[i]
+		}
+	}
+	return name
+}
+
+// Quote Returns the names quoted with quote, e.g. Dialect.QuoteIdentifier for dialects reserving some of them
+func (c MigrationColumns) Quote(quote func(name string) string) MigrationColumns {
+	return MigrationColumns{
+		Id:           quote(c.Id),
+		Name:         quote(c.Name),
+		File:         quote(c.File),
+		Version:      quote(c.Version),
+		CreatedAt:    quote(c.CreatedAt),
+		Checksum:     quote(c.Checksum),
+		VersionLabel: quote(c.VersionLabel),
+		Digest:       quote(c.Digest),
+		Success:      quote(c.Success),
+		DurationMs:   quote(c.DurationMs),
+		Algorithm:    quote(c.Algorithm),
+		AppliedBy:    quote(c.AppliedBy),
+		Size:         quote(c.Size),
+		NoOp:         quote(c.NoOp),
+	}
+}
+
+// orDefault Returns the names with the empty ones replaced by the default names
+func (c MigrationColumns) orDefault() MigrationColumns {
+	or := func(name string, fallback string) string {
+		if len(strings.TrimSpace(name)) == 0 {
+			return fallback
+		}
+		return name
+	}
+	d := DefaultMigrationColumns
+	return MigrationColumns{
+		Id:           or(c.Id, d.Id),
+		Name:         or(c.Name, d.Name),
+		File:         or(c.File, d.File),
+		Version:      or(c.Version, d.Version),
+		CreatedAt:    or(c.CreatedAt, d.CreatedAt),
+		Checksum:     or(c.Checksum, d.Checksum),
+		VersionLabel: or(c.VersionLabel, d.VersionLabel),
+		Digest:       or(c.Digest, d.Digest),
+		Success:      or(c.Success, d.Success),
+		DurationMs:   or(c.DurationMs, d.DurationMs),
+		Algorithm:    or(c.Algorithm, d.Algorithm),
+		AppliedBy:    or(c.AppliedBy, d.AppliedBy),
+		Size:         or(c.Size, d.Size),
+		NoOp:         or(c.NoOp, d.NoOp),
+	}
+}
+
+// validate Check that the names are valid unquoted identifiers, distinct ignoring case
+func (c MigrationColumns) validate() error {
+	seen := map[string]bool{}
+	for _, name := range c.orDefault().Names() {
+		if !column_name_pattern.MatchString(name) {
+			return errors.Errorf("invalid column name %q: only letters, digits and underscores are allowed", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return errors.Errorf("duplicate column name %q", name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package dsync
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const gzip_suffix = ".gz"
+
+// isCompressed Migration files ending with .gz are gzip compressed
+func isCompressed(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), gzip_suffix)
+}
+
+// trimCompressed Remove the .gz suffix of a compressed file name
+func trimCompressed(name string) string {
+	if isCompressed(name) {
+		return name[:len(name)-len(gzip_suffix)]
+	}
+	return name
+}
+
+// sameFile Compare migration file names, ignoring case and compression, so that compressing an applied file
+// does not turn it into a new migration
+func sameFile(a string, b string) bool {
+	return strings.EqualFold(trimCompressed(a), trimCompressed(b))
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file fs.File
+}
+
+// Read Never returns data along with io.EOF, the end of the file is reported by the next call
+func (f gzipFile) Read(p []byte) (int, error) {
+	n, err := f.Reader.Read(p)
+	if n > 0 && err == io.EOF {
+		return n, nil
+	}
+	return n, err
+}
+
+func (f gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+// OpenMigrationFile Open a migration file, transparently decompressing .gz files. Data sources read migration
+// scripts through it so that compressed and plain files are applied alike.
+func OpenMigrationFile(fsys fs.FS, filename string) (io.ReadCloser, error) {
+	file, err := fsys.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isCompressed(filename) {
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, filename)
+	}
+	return gzipFile{Reader: reader, file: file}, nil
+}
+
+// ReadMigrationFile Read a whole migration file, decompressed (see OpenMigrationFile)
+func ReadMigrationFile(fsys fs.FS, filename string) ([]byte, error) {
+	file, err := OpenMigrationFile(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.Wrap(err, filename)
+	}
+	return content, nil
+}
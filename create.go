@@ -0,0 +1,64 @@
+package dsync
+
+import (
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// default_version_width Number of digits of the first version of an empty directory, e.g. 0001__init.sql
+const default_version_width = 4
+
+// NewMigrationFilename Returns the file name of a new migration called name, numbered after the highest version of
+// the existing directory entries and zero-padded to the same width, e.g. 0000012__add_users.sql after
+// 0000011__add_orders.sql. The .sql extension is added when name has none. An empty directory starts at version 1.
+// Entries that are not migration files are ignored, migration files with an invalid name are reported.
+func NewMigrationFilename(existing []fs.DirEntry, name string) (string, error) {
+	return newMigrationFilename(existing, name, 1)
+}
+
+// NewMigrationFilename Like the package function NewMigrationFilename, an empty directory starting at BaseVersion
+// when set
+func (migrator Migrator) NewMigrationFilename(existing []fs.DirEntry, name string) (string, error) {
+	base := migrator.BaseVersion
+	if base == 0 {
+		base = 1
+	}
+	return newMigrationFilename(existing, name, base)
+}
+
+func newMigrationFilename(existing []fs.DirEntry, name string, base int64) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", errors.Errorf("invalid migration name %q", name)
+	}
+	if !isMigrationFile(name, nil) {
+		name += ".sql"
+	}
+
+	version, width := base-1, default_version_width
+	for _, entry := range existing {
+		if !entry.Type().IsRegular() || !isMigrationFile(entry.Name(), nil) || isDownFile(entry.Name(), nil) {
+			continue
+		}
+		m, err := ParseMigration(entry.Name())
+		if err != nil {
+			return "", err
+		}
+		if !m.Repeatable && m.Version > version {
+			version = m.Version
+			width = strings.Index(entry.Name(), migration_separator)
+		}
+	}
+
+	digits := strconv.FormatInt(version+1, 10)
+	if len(digits) < width {
+		digits = strings.Repeat("0", width-len(digits)) + digits
+	}
+	filename := digits + migration_separator + name
+	if _, err := ParseMigration(filename); err != nil {
+		return "", errors.Wrapf(err, "invalid migration name %q", name)
+	}
+	return filename, nil
+}
@@ -0,0 +1,74 @@
+package dsync
+
+import (
+	"context"
+	"time"
+)
+
+// Dialect What a database/sql data source needs to know about a database to keep the migration table, see
+// NewSource. The bundled SQL sources are dialects too; the optional TableQuoter, ColumnQuoter,
+// TableExistsChecker, ColumnUpgrader and TimestampCodec interfaces cover what some databases need beyond it.
+type Dialect interface {
+	// QuoteIdentifier Quotes a table or column name, e.g. with QuoteIdentifier(name, `"`, `"`)
+	QuoteIdentifier(name string) string
+	// Placeholder Returns the n-th parameter of a query, numbered from 1, e.g. $1, ? or @p1
+	Placeholder(n int) string
+	// CreateTableStatement Returns the statement creating the migration table, given its quoted name and its
+	// columns (quoted when the dialect is a ColumnQuoter). Config.CreateTableStatement takes precedence.
+	CreateTableStatement(table string, columns MigrationColumns) string
+	// TableExistsQuery Returns the query, and its arguments, selecting whether the migration table exists as a
+	// single boolean (or 0/1 integer), given its unquoted name
+	TableExistsQuery(name string) (string, []interface{})
+}
+
+// TableQuoter Optionally implemented by dialects quoting table names otherwise than with QuoteIdentifier, e.g.
+// schema qualified ones. Data sources whose dialect does not implement it reject schema qualified table names.
+type TableQuoter interface {
+	QuoteTable(name string) string
+}
+
+// ColumnQuoter Optionally implemented by dialects quoting the column names of the migration table in every query,
+// e.g. because some of them are reserved words
+type ColumnQuoter interface {
+	QuoteColumns() bool
+}
+
+// TableExistsChecker Optionally implemented by dialects looking the migration table up otherwise than with
+// TableExistsQuery
+type TableExistsChecker interface {
+	TableExists(ctx context.Context, source *SQLSource) (bool, error)
+}
+
+// ColumnUpgrade Column added to the migration table after its initial definition, by its default name (see
+// DefaultMigrationColumns), and its type
+type ColumnUpgrade struct {
+	Column     string
+	Definition string
+}
+
+// ColumnUpgrader Optionally implemented by dialects adding the columns of later releases to existing migration
+// tables. Without it, tables are used as they are.
+type ColumnUpgrader interface {
+	// Upgrades Columns to add when they are missing, in order. They must be nullable.
+	Upgrades() []ColumnUpgrade
+	// ColumnExists Reports whether the migration table has a column, reading through source.Writer()
+	ColumnExists(ctx context.Context, source *SQLSource, column string) (bool, error)
+	// AddColumnStatement Returns the statement adding a column, given the quoted name of the table
+	AddColumnStatement(table string, column string, definition string) string
+}
+
+// ServerVersionQuerier Optionally implemented by dialects able to report the version of the server, see
+// ServerVersioner
+type ServerVersionQuerier interface {
+	// ServerVersionQuery Returns the query selecting the version of the server as a single string
+	ServerVersionQuery() string
+}
+
+// TimestampCodec Optionally implemented by dialects whose driver does not read or write the CreatedAt column as a
+// time.Time
+type TimestampCodec interface {
+	// ParseTimestamp Converts a CreatedAt value returned by the driver
+	ParseTimestamp(value interface{}) (time.Time, error)
+	// FormatTimestamp Converts CreatedAt before it is written
+	FormatTimestamp(t time.Time) interface{}
+}
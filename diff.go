@@ -0,0 +1,212 @@
+package dsync
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Schema A simplified description of a database schema used to generate migrations
+type Schema struct {
+	Tables []Table
+}
+
+type Table struct {
+	Name string
+	// Definition The statement that creates the table
+	Definition string
+	Columns    []Column
+	Indexes    []Index
+}
+
+type Column struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	Default    string
+	HasDefault bool
+	PrimaryKey bool
+}
+
+type Index struct {
+	Name string
+	// Definition The statement that creates the index
+	Definition string
+}
+
+// SchemaIntrospector is implemented by data sources that can describe their schema, excluding the
+// migration table. Only the sqlite data source implements it at the moment.
+type SchemaIntrospector interface {
+	Schema() (*Schema, error)
+}
+
+// GenerateFromDiff Introspect both databases and write a migration to outDir transforming the current schema into
+// the desired one. The file is named after the highest version found in outDir, e.g. 0004__schema_diff.sql.
+// No file is written when both schemas match.
+//
+// Limitations: the generated statements are dialect specific (sqlite only for now) and cover missing tables,
+// columns and indexes. Nothing is ever dropped or altered in place; tables, columns and indexes missing from the
+// desired schema, as well as column definition changes, are listed as comments to be handled by hand.
+func (migrator Migrator) GenerateFromDiff(current, desired DataSource, outDir string) error {
+	from, err := introspect(current)
+	if err != nil {
+		return errors.Wrap(err, "current schema")
+	}
+	to, err := introspect(desired)
+	if err != nil {
+		return errors.Wrap(err, "desired schema")
+	}
+
+	script := DiffSchemas(from, to)
+	if len(script) == 0 {
+		return nil
+	}
+
+	filename, err := nextMigrationFilename(outDir, "schema_diff")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outDir, filename), []byte(script), 0644)
+}
+
+func introspect(ds DataSource) (*Schema, error) {
+	si, ok := ds.(SchemaIntrospector)
+	if !ok {
+		return nil, errors.New("data source does not support schema introspection")
+	}
+	return si.Schema()
+}
+
+// DiffSchemas Returns the statements transforming the current schema into the desired one, or an empty string when
+// there is nothing to do. See GenerateFromDiff for the limitations.
+func DiffSchemas(current, desired *Schema) string {
+	var sb strings.Builder
+
+	tables := make(map[string]*Table)
+	for i := range current.Tables {
+		tables[strings.ToLower(current.Tables[i].Name)] = &current.Tables[i]
+	}
+
+	for _, table := range desired.Tables {
+		existing, ok := tables[strings.ToLower(table.Name)]
+		if !ok {
+			sb.WriteString(strings.TrimSuffix(strings.TrimSpace(table.Definition), ";"))
+			sb.WriteString(";\n\n")
+			for _, index := range table.Indexes {
+				writeStatement(&sb, index.Definition)
+			}
+			continue
+		}
+		delete(tables, strings.ToLower(table.Name))
+		diffTable(&sb, existing, &table)
+	}
+
+	for _, table := range current.Tables {
+		if _, ok := tables[strings.ToLower(table.Name)]; ok {
+			sb.WriteString("-- table " + quoteIdentifier(table.Name) + " is not part of the desired schema\n")
+		}
+	}
+
+	return sb.String()
+}
+
+func diffTable(sb *strings.Builder, current, desired *Table) {
+	columns := make(map[string]*Column)
+	for i := range current.Columns {
+		columns[strings.ToLower(current.Columns[i].Name)] = &current.Columns[i]
+	}
+	for _, column := range desired.Columns {
+		existing, ok := columns[strings.ToLower(column.Name)]
+		if !ok {
+			sb.WriteString("ALTER TABLE " + quoteIdentifier(desired.Name) + " ADD COLUMN " + columnDefinition(&column) + ";\n")
+			continue
+		}
+		delete(columns, strings.ToLower(column.Name))
+		if columnDefinition(existing) != columnDefinition(&column) {
+			sb.WriteString("-- column " + quoteIdentifier(desired.Name) + "." + quoteIdentifier(column.Name) +
+				" changed from " + columnDefinition(existing) + " to " + columnDefinition(&column) + "\n")
+		}
+	}
+	for _, column := range current.Columns {
+		if _, ok := columns[strings.ToLower(column.Name)]; ok {
+			sb.WriteString("-- column " + quoteIdentifier(desired.Name) + "." + quoteIdentifier(column.Name) + " is not part of the desired schema\n")
+		}
+	}
+
+	indexes := make(map[string]bool)
+	for _, index := range current.Indexes {
+		indexes[strings.ToLower(index.Name)] = true
+	}
+	for _, index := range desired.Indexes {
+		if !indexes[strings.ToLower(index.Name)] {
+			writeStatement(sb, index.Definition)
+		}
+		delete(indexes, strings.ToLower(index.Name))
+	}
+	for _, index := range current.Indexes {
+		if indexes[strings.ToLower(index.Name)] {
+			sb.WriteString("-- index " + quoteIdentifier(index.Name) + " is not part of the desired schema\n")
+		}
+	}
+}
+
+func columnDefinition(c *Column) string {
+	var sb strings.Builder
+
+	sb.WriteString(quoteIdentifier(c.Name))
+	if c.Type != "" {
+		sb.WriteString(" ")
+		sb.WriteString(c.Type)
+	}
+	if c.PrimaryKey {
+		sb.WriteString(" PRIMARY KEY")
+	}
+	if c.NotNull {
+		sb.WriteString(" NOT NULL")
+	}
+	if c.HasDefault {
+		sb.WriteString(" DEFAULT ")
+		sb.WriteString(c.Default)
+	}
+	return sb.String()
+}
+
+func writeStatement(sb *strings.Builder, statement string) {
+	sb.WriteString(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+	sb.WriteString(";\n")
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// nextMigrationFilename Returns the file name following the highest versioned migration in dir, keeping the
+// zero padding of the existing files (4 digits for an empty directory)
+func nextMigrationFilename(dir string, name string) (string, error) {
+	var version int64
+	var width = 4
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading directory entries")
+	}
+	for _, entry := range entries {
+		m, err := ParseMigration(entry.Name())
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		if m.Version >= version {
+			version = m.Version
+			width = len(entry.Name()) - len(strings.TrimLeft(entry.Name(), "0123456789"))
+		}
+	}
+
+	digits := strconv.FormatInt(version+1, 10)
+	if len(digits) < width {
+		digits = strings.Repeat("0", width-len(digits)) + digits
+	}
+	return digits + "__" + name + ".sql", nil
+}
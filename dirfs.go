@@ -0,0 +1,23 @@
+package dsync
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DirFS Returns the file system and base path to set as Config.FileSystem and Config.Basepath to read migrations
+// from a directory on disk at runtime, e.g.
+//
+//	fsys, basepath := dsync.DirFS("./migrations")
+//	ds, err := sqlite.New(dsn, &dsync.Config{FileSystem: fsys, Basepath: basepath})
+//
+// The directory itself is the root of the file system, so that the base path never contains OS specific
+// separators: fs.FS paths are always slash separated, even on Windows, where dir may use either separator.
+// Relative directories are resolved against the working directory when DirFS is called.
+func DirFS(dir string) (fs.FS, string) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return os.DirFS(dir), "."
+}
@@ -1,10 +1,17 @@
 package dsync
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"io"
 	"io/fs"
+	"log"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,18 +26,76 @@ const (
 	err_new_migration
 	err_migration_conflict
 	err_migration_out_of_order
+	err_repeatable_changed
+	// err_new_out_of_order A new migration behind the current version, applied because Migrator.OutOfOrder is set
+	err_new_out_of_order
 )
 
 const DEFAULT_TABLE_NAME = "dsync_migration_info"
 
 type Migration struct {
-	Id        uint32
-	Name      string
-	File      string
-	Version   int64
-	CreatedAt time.Time
-	Checksum  int64
+	Id      uint32
+	Name    string
+	File    string
+	Version int64
+	// VersionLabel Human friendly version derived from the file name (e.g. "2024-01-15 09:30" for timestamp
+	// versions). Version remains the ordering key.
+	VersionLabel string
+	CreatedAt    time.Time
+	Checksum     int64
+	// Digest Algorithm prefixed digest of the file ("sha256:<hex>"), empty unless recorded with the SHA256
+	// checksum algorithm
+	Digest string
+	// Algorithm Checksum algorithm the migration was recorded with. Applied files are verified with the algorithm
+	// of their record rather than the configured one.
+	Algorithm ChecksumAlgorithm
 	Success   bool
+	// AppliedBy Who applied the migration, see Migrator.AppliedBy. Empty for migrations recorded before it was
+	// tracked.
+	AppliedBy string
+	// Size Number of bytes the checksum was computed over (the script, as hashed, followed by its blob files). 0 for
+	// migrations recorded before it was tracked.
+	Size int64
+
+	// LockRisk Expected lock impact of the migration, only set by Migrator.Plan
+	LockRisk LockRisk
+
+	// Condition Query declared with "-- dsync:when <query>" returning a single boolean. The migration is skipped,
+	// and not recorded, while the condition is false
+	Condition string
+
+	// Requirement Server version declared with "-- dsync:require server>=<version>", nil when none is
+	Requirement *ServerRequirement
+	// NoOp The server does not meet Requirement: the migration is recorded as applied without being executed, and is
+	// not considered again once the server is upgraded. Persisted in the migration table, rolling it back only
+	// deletes its record.
+	NoOp bool
+
+	// NoTransaction The migration file declared "-- dsync:transactional=false" and is applied outside of
+	// any transaction
+	NoTransaction bool
+
+	// Placeholders Values of the ${name} tokens substituted in the script when it is applied, see
+	// ExpandPlaceholders. Set from Migrator.Placeholders.
+	Placeholders map[string]string
+
+	// TemplateData Data the script is rendered with by text/template before it is applied, see RenderTemplate.
+	// Set from Migrator.TemplateData.
+	TemplateData interface{}
+
+	// Blobs Binary data files inserted once the script has run, declared with "-- dsync:blob table=<table>
+	// column=<column> file=<file>". Their content is part of the checksum.
+	Blobs []Blob
+
+	// Repeatable The file is named R__<name>.sql. Repeatable migrations have no version (0), are applied after
+	// the versioned ones and are applied again whenever their checksum changes.
+	Repeatable bool
+	// Duration Time taken to execute the migration script, recorded in milliseconds. Zero for migrations recorded
+	// before it was tracked.
+	Duration time.Duration
+	// OutOfOrder The migration was new but behind the current version, and is applied because
+	// Migrator.OutOfOrder is set
+	OutOfOrder bool
 }
 
 type MigrationInfo struct {
@@ -42,6 +107,9 @@ type MigrationInfo struct {
 type MigrationError struct {
 	Err       error
 	Migration *Migration
+	// Applied Migrations committed by the failed Migrate call before the failure, in order, e.g. to report "applied
+	// v5, v6; failed on v7". Only set on errors returned by Migrate and its variants.
+	Applied []*Migration
 }
 
 func (e MigrationError) Error() string {
@@ -53,6 +121,121 @@ func (e MigrationError) Error() string {
 	return builder.String()
 }
 
+func (e MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Verification failures returned by Migrate, Plan and Validate match (errors.Is) one of these
+var (
+	// ErrChecksumMismatch An applied migration file has been modified since
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	// ErrConflict A new migration file has the version of an applied migration
+	ErrConflict = errors.New("migration version conflict")
+	// ErrOutOfOrder A new migration file is behind the current version and Migrator.OutOfOrder is not set
+	ErrOutOfOrder = errors.New("migration out of order")
+)
+
+// ChecksumMismatchError An applied migration file has been modified since, matches ErrChecksumMismatch
+type ChecksumMismatchError struct {
+	File string
+	// Expected Checksum recorded in the database
+	Expected int64
+	// Actual Checksum of the file
+	Actual int64
+	// ExpectedDigest, ActualDigest SHA-256 digests, set instead of the checksums when both sides have one
+	ExpectedDigest string
+	ActualDigest   string
+	// ExpectedSize, ActualSize Size of the file when it was applied and now, hinting at what changed since only the
+	// checksum of the content is recorded. ExpectedSize is 0 for migrations recorded before sizes were.
+	ExpectedSize int64
+	ActualSize   int64
+}
+
+func (e ChecksumMismatchError) Error() string {
+	if e.ExpectedDigest != "" && e.ActualDigest != "" {
+		return e.File + ": migration file checksum conflict. expected " + e.ExpectedDigest + ", found " + e.ActualDigest + e.sizeHint() + checksum_mismatch_remedy
+	}
+	return e.File + ": migration file checksum conflict. expected " + strconv.FormatInt(e.Expected, 10) +
+		", found " + strconv.FormatInt(e.Actual, 10) + e.sizeHint() + checksum_mismatch_remedy
+}
+
+// checksum_mismatch_remedy How to resolve a checksum mismatch, appended to ChecksumMismatchError messages
+const checksum_mismatch_remedy = "; restore the applied file, or review the change and record its checksum with Migrator.Repair"
+
+// sizeHint Describe how the size of the file changed, e.g. " (file is now 40 bytes longer)", empty when the size
+// was not recorded
+func (e ChecksumMismatchError) sizeHint() string {
+	if e.ExpectedSize <= 0 {
+		return ""
+	}
+	switch delta := e.ActualSize - e.ExpectedSize; {
+	case delta > 0:
+		return " (file is now " + strconv.FormatInt(delta, 10) + " bytes longer)"
+	case delta < 0:
+		return " (file is now " + strconv.FormatInt(-delta, 10) + " bytes shorter)"
+	}
+	return " (file size unchanged, " + strconv.FormatInt(e.ActualSize, 10) + " bytes)"
+}
+
+func (e ChecksumMismatchError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// ConflictError A new migration file has the version of an applied migration, matches ErrConflict
+type ConflictError struct {
+	File    string
+	Version int64
+}
+
+func (e ConflictError) Error() string {
+	return e.File + ": migration version " + strconv.FormatInt(e.Version, 10) + " already applied"
+}
+
+func (e ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// OutOfOrderError A new migration file is behind the current version, matches ErrOutOfOrder
+type OutOfOrderError struct {
+	File           string
+	Version        int64
+	CurrentVersion int64
+}
+
+func (e OutOfOrderError) Error() string {
+	return e.File + ": version " + strconv.FormatInt(e.Version, 10) + " is behind current version " +
+		strconv.FormatInt(e.CurrentVersion, 10) + ". Enable out of order to migrate this script"
+}
+
+func (e OutOfOrderError) Unwrap() error {
+	return ErrOutOfOrder
+}
+
+// ErrRunTimeout Matches (errors.Is) the RunTimeoutError returned when Migrator.RunTimeout is exceeded
+var ErrRunTimeout = errors.New("migration run timed out")
+
+type RunTimeoutError struct {
+	Timeout time.Duration
+	// Completed Number of migrations committed before the timeout
+	Completed int
+	Err       error
+}
+
+func (e RunTimeoutError) Error() string {
+	return "migration run timed out after " + e.Timeout.String() + " with " + strconv.Itoa(e.Completed) +
+		" migration(s) completed: " + e.Err.Error()
+}
+
+func (e RunTimeoutError) Is(target error) bool {
+	return target == ErrRunTimeout
+}
+
+func (e RunTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// DataSource A database migrations are applied to. Data sources that open their own database handle also implement
+// io.Closer to release it; handles provided by the caller are left open.
 type DataSource interface {
 	// GetMigrationInfo Returns table name and other information
 	GetMigrationInfo() (*MigrationInfo, error)
@@ -69,20 +252,160 @@ type DataSource interface {
 	// SetTransactionSuccessful SetTransactionSuccessful notify the data source whether to commit or rollback when EndTransaction is called
 	SetTransactionSuccessful(s bool)
 
-	// ApplyMigration ApplyMigration Applies the given migration
+	// ApplyMigration ApplyMigration Applies the given migration. Migrations marked NoTransaction are applied
+	// while no transaction is active and must be executed (and logged) directly against the database. A non zero
+	// Id identifies the recorded row of a changed repeatable migration, which the new record replaces
 	ApplyMigration(migration *Migration) error
 
-	// EndTransaction EndTransaction Commit or rollback the active transaction
-	EndTransaction()
+	// EndTransaction EndTransaction Commit or rollback the active transaction. Does nothing when no transaction is
+	// active, e.g. after BeginTransaction failed. Migrator only ends the transactions it began successfully. Returns
+	// the error of the commit or rollback, a failed commit leaves nothing of the transaction applied.
+	EndTransaction() error
 
-	// Return the underlying database handle
+	// Return the underlying database handle, e.g. to run health checks or tune the connection pool
 	Handle() *sql.DB
 }
 
+// DB Returns the database handle of a data source, false if it has none. Handle is part of DataSource, this only
+// spares generic code the nil checks.
+func DB(ds DataSource) (*sql.DB, bool) {
+	if ds == nil {
+		return nil, false
+	}
+	db := ds.Handle()
+	return db, db != nil
+}
+
+// ContextDataSource is implemented by data sources that accept the context passed to
+// Migrator.MigrateContext. The context carries cancellation as well as any request scoped
+// values (tenant id, trace id, ...) set by the caller.
+type ContextDataSource interface {
+	DataSource
+
+	// GetMigrationInfoContext GetMigrationInfo using the given context
+	GetMigrationInfoContext(ctx context.Context) (*MigrationInfo, error)
+
+	// BeginTransactionContext BeginTransaction using the given context
+	BeginTransactionContext(ctx context.Context) error
+
+	// ApplyMigrationContext ApplyMigration using the given context
+	ApplyMigrationContext(ctx context.Context, migration *Migration) error
+}
+
+// MultiPathDataSource is implemented by data sources whose change set spans several directories (see
+// Config.Basepaths). GetPath then returns the root of the change set file system, which the recorded file names
+// are relative to.
+type MultiPathDataSource interface {
+	// GetPaths Returns the directories of the change set, nil if it is the single directory returned by GetPath
+	GetPaths() []string
+}
+
+// Locker is implemented by data sources that can serialize migration runs across processes
+// (see Migrator.UseLock). Unlock must release the lock even after a failed migration.
+type Locker interface {
+	// Lock Block until the migration lock is held or ctx is done
+	Lock(ctx context.Context) error
+
+	// Unlock Release the lock acquired by Lock
+	Unlock() error
+}
+
+// TransactionLocker is implemented by Lockers whose lock is only acquired when a transaction begins, e.g. the
+// write lock of SQLite. The applied migrations read before are checked again once it is held, since another run may
+// have applied them in the meantime.
+type TransactionLocker interface {
+	// LocksTransactions Reports whether the transactions begun from now on take the lock
+	LocksTransactions() bool
+}
+
+// ConditionEvaluator is implemented by data sources supporting conditional migrations ("-- dsync:when <query>").
+// The query must be evaluated within the active transaction, if any.
+type ConditionEvaluator interface {
+	// EvaluateConditionContext Run the condition query with the context of the run
+	EvaluateConditionContext(ctx context.Context, query string) (bool, error)
+}
+
+// IntegrityChecker is implemented by data sources that can verify foreign key and constraint
+// integrity once a migration run has been committed
+type IntegrityChecker interface {
+	// CheckIntegrityContext Returns an *IntegrityError describing every violation found, using the context of the run
+	CheckIntegrityContext(ctx context.Context) error
+}
+
+// IntegrityViolation A single violation reported by an integrity check
+type IntegrityViolation struct {
+	Table      string
+	Constraint string
+	Detail     string
+}
+
+type IntegrityError struct {
+	Violations []IntegrityViolation
+}
+
+func (e IntegrityError) Error() string {
+	var builder strings.Builder
+
+	builder.WriteString("integrity check failed: ")
+	builder.WriteString(strconv.Itoa(len(e.Violations)))
+	builder.WriteString(" violation(s)")
+	for _, v := range e.Violations {
+		builder.WriteString("; ")
+		builder.WriteString(v.Table)
+		if v.Constraint != "" {
+			builder.WriteString(" (")
+			builder.WriteString(v.Constraint)
+			builder.WriteString(")")
+		}
+		if v.Detail != "" {
+			builder.WriteString(": ")
+			builder.WriteString(v.Detail)
+		}
+	}
+	return builder.String()
+}
+
 type Config struct {
+	// FileSystem Change set the migration files are read from. Every read (listing, directives, checksums, scripts,
+	// blobs, down scripts) goes through it, with Open or fs.ReadDir and fs.ReadFile, and nothing is cached in
+	// between, so a wrapper transforming content, e.g. decrypting files stored encrypted at rest, applies alike to
+	// what is hashed and what is executed. Such a wrapper must return the same content every time a file is opened.
 	FileSystem fs.FS
 	Basepath   string
-	TableName  string
+	// TableName Name of the migration table, schema qualified (schema.table) only for the databases supporting it,
+	// i.e. Postgres
+	TableName string
+
+	// Basepaths Several directories of FileSystem merged by version into a single change set, instead of
+	// Basepath (e.g. one directory per service of a monorepo). Files are then recorded with their path relative
+	// to the root of FileSystem, so that identically named files of different directories remain distinct, and
+	// versions must be unique across all the directories.
+	Basepaths []string
+
+	// Extensions Extensions of migration files, matched ignoring case and optionally followed by .gz, e.g.
+	// []string{".sql", ".pgsql"}. Defaults to .sql.
+	Extensions []string
+
+	// MultiStatement Execute each migration file with a single Exec instead of splitting it with SplitStatements.
+	// Only enable it for drivers running every statement of a multi statement Exec (e.g. MySQL with
+	// multiStatements=true).
+	MultiStatement bool
+
+	// CreateTableStatement Statement creating the migration table, used verbatim instead of the data source's
+	// generated DDL (see TableCreator), e.g. to follow local column type or collation conventions. It must create
+	// the table named by TableName with every column of MigrationTableColumns, or their names set in Columns.
+	CreateTableStatement string
+
+	// Columns Names of the columns of the migration table, e.g. snake_case ones, used by every query of the data
+	// source. Empty names default to those of DefaultMigrationColumns. Names are used unquoted (bracketed on SQL
+	// Server), so they must not be reserved words. Existing tables are not renamed.
+	Columns MigrationColumns
+
+	// SessionSetup Statements run at the start of every migration transaction, and on the connection reading or
+	// creating the migration table and applying migrations outside of a transaction, e.g.
+	// "SET search_path TO tenant_5" to migrate one schema of a multi-tenant Postgres database. They are not part
+	// of any migration: neither checksummed nor recorded in the migration table.
+	SessionSetup []string
 }
 
 func (cfg *Config) validate() error {
@@ -90,13 +413,153 @@ func (cfg *Config) validate() error {
 		return errors.New("missing migration changeset source")
 	}
 
-	if len(strings.TrimSpace(cfg.Basepath)) == 0 {
+	if len(cfg.Basepaths) > 0 {
+		if len(strings.TrimSpace(cfg.Basepath)) > 0 {
+			return errors.New("basepath and basepaths are mutually exclusive")
+		}
+		seen := map[string]bool{}
+		for _, dir := range cfg.Basepaths {
+			if len(strings.TrimSpace(dir)) == 0 {
+				return errors.New("empty basepath in basepaths")
+			}
+			if seen[path.Clean(dir)] {
+				return errors.Errorf("duplicate basepath %q", dir)
+			}
+			seen[path.Clean(dir)] = true
+		}
+	} else if len(strings.TrimSpace(cfg.Basepath)) == 0 {
 		return errors.New("empty basepath")
 	}
 
+	for _, ext := range cfg.Extensions {
+		if len(ext) < 2 || !strings.HasPrefix(ext, ".") {
+			return errors.Errorf("invalid extension %q: extensions start with a dot, e.g. .sql", ext)
+		}
+	}
+
+	if len(strings.TrimSpace(cfg.TableName)) > 0 && !table_name_pattern.MatchString(cfg.TableName) {
+		return errors.Errorf("invalid table name %q: only letters, digits and underscores are allowed, optionally qualified as schema.table", cfg.TableName)
+	}
+
+	for _, statement := range cfg.SessionSetup {
+		if len(strings.TrimSpace(statement)) == 0 {
+			return errors.New("empty session setup statement")
+		}
+	}
+
+	if err := cfg.Columns.validate(); err != nil {
+		return err
+	}
+
+	if len(strings.TrimSpace(cfg.CreateTableStatement)) > 0 {
+		if err := validateCreateTableStatement(cfg.CreateTableStatement, cfg.ColumnsOrDefault().Names()); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// table_name_pattern Migration table names, optionally schema qualified
+var table_name_pattern = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)?$`)
+
+// MigrationTableColumns Columns of the migration table every data source reads and writes
+var MigrationTableColumns = DefaultMigrationColumns.Names()
+
+// identifier_pattern Unquoted words of a statement, quotes around identifiers are ignored
+var identifier_pattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// ValidateCreateTableStatement Check that a statement creating the migration table (see
+// Config.CreateTableStatement) declares every column of MigrationTableColumns. Column names are matched ignoring
+// case and quotes; their types are up to the statement.
+func ValidateCreateTableStatement(statement string) error {
+	return validateCreateTableStatement(statement, MigrationTableColumns)
+}
+
+// validateCreateTableStatement Check that a statement creating the migration table declares every one of columns
+func validateCreateTableStatement(statement string, columns []string) error {
+	var missing []string
+
+	words := map[string]bool{}
+	for _, word := range identifier_pattern.FindAllString(statement, -1) {
+		words[strings.ToLower(word)] = true
+	}
+	if !words["create"] || !words["table"] {
+		return errors.New("invalid create table statement: not a CREATE TABLE statement")
+	}
+	for _, column := range columns {
+		if !words[strings.ToLower(column)] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("invalid create table statement: missing column(s) %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// TableCreator is implemented by data sources exposing the statement creating their migration table, e.g. for
+// operators pre-creating the table. Tables created beforehand are only extended with the columns they lack.
+type TableCreator interface {
+	// CreateTableStatement Returns Config.CreateTableStatement when set, the generated DDL otherwise
+	CreateTableStatement() string
+}
+
+// TableInspector is implemented by data sources that can tell whether their migration table exists without creating
+// it, e.g. for tooling routing an uninitialized database to Baseline rather than Migrate
+type TableInspector interface {
+	// TableName Returns the name of the migration table, as configured
+	TableName() string
+
+	// TableExists Reports whether the migration table exists. Unlike GetMigrationInfo, it never creates it.
+	TableExists() (bool, error)
+}
+
+// MigrationInfoReader is implemented by data sources that can read their migration table without creating or
+// altering it, for the commands that never write (Validate, Status, Plan...), e.g. run by a read-only database user
+type MigrationInfoReader interface {
+	// ReadMigrationInfo Same as GetMigrationInfo, reporting nothing applied when the table does not exist
+	ReadMigrationInfo(ctx context.Context) (*MigrationInfo, error)
+}
+
+// readMigrationInfo Read the applied migrations without writing to the database: through a MigrationInfoReader,
+// or GetMigrationInfo once a TableInspector found the migration table. A missing table means nothing is applied.
+func readMigrationInfo(ctx context.Context, ds DataSource) (*MigrationInfo, error) {
+	if reader, ok := ds.(MigrationInfoReader); ok {
+		return reader.ReadMigrationInfo(ctx)
+	}
+	if inspector, ok := ds.(TableInspector); ok {
+		exists, err := inspector.TableExists()
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return &MigrationInfo{TableName: inspector.TableName()}, nil
+		}
+	}
+	return getMigrationInfo(ctx, ds)
+}
+
+// TableExists Reports whether the migration table of ds exists, without creating it, see TableInspector
+func TableExists(ds DataSource) (bool, error) {
+	inspector, ok := ds.(TableInspector)
+	if !ok {
+		return false, errors.New("data source cannot report whether its migration table exists")
+	}
+	return inspector.TableExists()
+}
+
+// QuoteIdentifier Quote an identifier with the dialect's quote characters (e.g. `"` and `"`, or "[" and "]"),
+// doubling the closing quote character within the identifier
+func QuoteIdentifier(name string, open string, close string) string {
+	return open + strings.ReplaceAll(name, close, close+close) + close
+}
+
+// ColumnsOrDefault Returns Columns with the empty names replaced by the default ones
+func (cfg Config) ColumnsOrDefault() MigrationColumns {
+	return cfg.Columns.orDefault()
+}
+
 func (cfg Config) TableNameOrDefault() string {
 	if len(strings.TrimSpace(cfg.TableName)) > 0 {
 		return cfg.TableName
@@ -104,6 +567,14 @@ func (cfg Config) TableNameOrDefault() string {
 	return DEFAULT_TABLE_NAME
 }
 
+// BasepathOrDefault Basepath, or the root of FileSystem when the change set spans Basepaths
+func (cfg Config) BasepathOrDefault() string {
+	if len(cfg.Basepaths) > 0 {
+		return "."
+	}
+	return cfg.Basepath
+}
+
 func ValidateConfig(cfg *Config) error {
 	if cfg == nil {
 		return errors.New("null configuration")
@@ -111,26 +582,297 @@ func ValidateConfig(cfg *Config) error {
 	return cfg.validate()
 }
 
+// TransactionMode How migrations are grouped into transactions
+type TransactionMode int
+
+const (
+	// DefaultTransactionMode The data source's preferred mode when it implements TransactionModePreferrer,
+	// SingleTransaction otherwise
+	DefaultTransactionMode TransactionMode = iota
+	// SingleTransaction Apply all pending migrations in one transaction
+	SingleTransaction
+	// PerMigration Commit after each migration so that a failure leaves the previously applied migrations in place
+	PerMigration
+)
+
+// TransactionModePreferrer is implemented by data sources recommending a transaction mode, e.g. databases whose
+// DDL statements commit implicitly and cannot be rolled back as a group
+type TransactionModePreferrer interface {
+	PreferredTransactionMode() TransactionMode
+}
+
+// batchSize Number of migrations committed together, 0 for all of them
+func (migrator Migrator) batchSize(ds DataSource) int {
+	if migrator.TransactionMode == PerMigration {
+		return 1
+	}
+	if migrator.BatchSize > 0 {
+		return migrator.BatchSize
+	}
+	if migrator.transactionMode(ds) == PerMigration {
+		return 1
+	}
+	return 0
+}
+
+// transactionMode Resolve DefaultTransactionMode
+func (migrator Migrator) transactionMode(ds DataSource) TransactionMode {
+	if migrator.TransactionMode != DefaultTransactionMode {
+		return migrator.TransactionMode
+	}
+	if preferrer, ok := ds.(TransactionModePreferrer); ok {
+		return preferrer.PreferredTransactionMode()
+	}
+	return SingleTransaction
+}
+
 type Migrator struct {
 	OutOfOrder bool
+
+	TransactionMode TransactionMode
+
+	// BatchSize Commit after every BatchSize applied migrations, so that progress through a large change set
+	// survives a crash and locks are held for shorter windows, while a failure only rolls back the current batch.
+	// Zero leaves grouping to TransactionMode. Takes precedence over the preferred mode of the data source, but not
+	// over an explicit PerMigration.
+	BatchSize int
+
+	// RunTimeout Bounds the whole Migrate call. When exceeded, the migration in progress is rolled back and a
+	// RunTimeoutError is returned. Combine with PerMigration to keep the migrations completed so far.
+	RunTimeout time.Duration
+
+	// DetectIncompleteRuns Run DetectIncomplete before applying anything, failing with an IncompleteRunError when the
+	// schema already has the tables of pending migrations rather than with the error of their CREATE TABLE
+	DetectIncompleteRuns bool
+
+	// StrictApply Run Validate over the whole change set before applying anything, once the lock is held, and abort
+	// the run with its ValidationError when an applied file was modified, a version conflicts, a pending file is
+	// out of order (unless OutOfOrder is set) or any other problem is found, instead of applying the migrations
+	// that come before the first failure.
+	StrictApply bool
+
+	// PostIntegrityCheck Run the data source's integrity check after a successful migration.
+	// The data source must implement IntegrityChecker.
+	PostIntegrityCheck bool
+
+	// FromVersion, ToVersion Only apply migrations within the inclusive [FromVersion, ToVersion] window. Zero
+	// leaves the respective bound open. Files outside of the window are neither applied nor checked for
+	// conflicts or out of order versions, but already applied files are still verified against their
+	// checksum. Note that skipping versions below the window makes them out of order for later runs.
+	FromVersion int64
+	ToVersion   int64
+
+	// TargetVersion Stop after applying the migration with this version, leaving later ones pending. Zero applies
+	// all migrations. Unlike ToVersion, a migration file with this version must exist.
+	TargetVersion int64
+
+	// StrictRequirements Fail with a RequirementError when the server does not meet the "-- dsync:require" directive
+	// of a pending migration, instead of recording the migration as a no-op (see Migration.NoOp)
+	StrictRequirements bool
+
+	// AuditSink Receives a summary of every Migrate run, successful or not
+	AuditSink AuditSink
+
+	// Clock Source of the CreatedAt timestamp recorded for applied migrations. Defaults to time.Now().UTC()
+	Clock func() time.Time
+
+	// AppliedBy Recorded with every applied migration for auditing, e.g. the user, host or application running the
+	// migrations. Defaults to user@host of the current process.
+	AppliedBy string
+
+	// ChecksumAlgorithm Algorithm recording and verifying the content of migration files. Defaults to CRC32.
+	ChecksumAlgorithm ChecksumAlgorithm
+
+	// NormalizeLineEndings Strip carriage returns from migration files before computing their checksums, so
+	// that CRLF and LF checkouts of the same file verify alike. Rows recorded without it may need Repair.
+	NormalizeLineEndings bool
+
+	// ChecksumMode Hash migration files as written (Raw, the default) or after NormalizeSQL (Normalized), so that
+	// only edits to the executed SQL trip the checksum verification. Switching modes changes the checksum of every
+	// file: run Repair once to re-hash the applied migrations.
+	ChecksumMode ChecksumMode
+
+	// Placeholders Values substituted for ${name} tokens in migration scripts when they are applied (e.g. a per
+	// tenant schema name). Checksums are computed on the file before substitution.
+	Placeholders map[string]string
+
+	// TemplateData Render migration scripts with text/template and this data before applying them, e.g. for
+	// environment specific statements ({{if .Production}} ... {{end}}). Checksums are computed on the template.
+	TemplateData interface{}
+
+	// BeforeMigrate Called once per run with every migration about to be applied, before the first one is, e.g. to
+	// take a backup keyed off the whole pending set. It runs within the run's lock and, unless the first pending
+	// migration opts out of transactions, within the migration transaction: returning an error aborts the run
+	// before anything is applied. Not called when nothing is pending.
+	//
+	// Like the other hooks, it receives the context of the run (the one given to MigrateContext).
+	BeforeMigrate func(ctx context.Context, ds DataSource, pending []*Migration) error
+
+	// BeforeEach Called before applying each migration
+	BeforeEach func(ctx context.Context, m *Migration)
+
+	// AfterEach Called after applying each migration with the error it failed with, if any
+	AfterEach func(ctx context.Context, m *Migration, err error)
+
+	// OnSkip Called for each migration file that is not applied, with the reason (SkipApplied, SkipOutsideWindow,
+	// SkipConditionFalse, SkipRequirementNotMet)
+	OnSkip func(ctx context.Context, m *Migration, reason string)
+
+	// OnOutOfOrder Called after applying a migration whose version is behind the current version, which only
+	// happens when OutOfOrder is set. Such migrations also have Migration.OutOfOrder set.
+	OnOutOfOrder func(ctx context.Context, m *Migration)
+
+	// Events Receives a MigrationEvent as each Migrate call progresses, ending with EventCompleted. Sends never
+	// block: events the channel is not ready to receive are dropped, so use a buffered channel sized for the
+	// expected number of events when none may be missed. The channel is not closed, it can be shared by several
+	// calls.
+	Events chan<- MigrationEvent
+
+	// Tracer Receives a span for every Migrate call and each migration it applies, see Tracer
+	Tracer Tracer
+
+	// RetryPolicy Retry the run when it fails on a transient error, classified by the data source when it
+	// implements TransientErrorClassifier and by IsTransientError otherwise. Not retried by default.
+	RetryPolicy RetryPolicy
+
+	// StrictContiguous Refuse to run unless the versions of the migration files count up by one, from BaseVersion
+	// (1 when zero) or from FromVersion when set, as a guard against lost files. Not supported with SemanticVersions.
+	StrictContiguous bool
+
+	// BaseVersion Version of the first migration file when the change set does not start at 1, e.g. 100 for a
+	// history starting at 0000100__init.sql. Files below it are rejected, and StrictContiguous counts from it.
+	BaseVersion int64
+
+	// UseLock Hold the data source's migration lock for the whole run so that concurrent instances
+	// migrating the same database wait for each other. The data source must implement Locker.
+	UseLock bool
+
+	// UseLockTable Serialize concurrent runs with a row of a dedicated <table>_lock table, created on first use,
+	// instead of (or on top of) the database's own locks. Portable to any database, see TableLocker. The row is
+	// held for the whole run and deleted when it ends.
+	UseLockTable bool
+
+	// LockTableStaleAfter Take over a lock row taken longer ago than this, e.g. left behind by a crashed process.
+	// Zero waits for the row to be released however old it is. Keep it well above the duration of the longest run,
+	// and mind clock skew between hosts.
+	LockTableStaleAfter time.Duration
+
+	// FilenamePattern Regular expression matching the names of the migration files, with the named capture
+	// groups version and name (see ParseMigrationPattern), for file names that do not follow the default
+	// <version>__<name>.sql convention. Files that do not match are skipped. Nil uses ParseMigration.
+	FilenamePattern *regexp.Regexp
+
+	// SemanticVersions Accept dotted versions (1.2.10__add_users.sql, or a FilenamePattern version group such as
+	// V1.2.10) and order them component-wise. Versions are recorded encoded by SemanticVersion, so enable it
+	// before the first migration is applied.
+	SemanticVersions bool
+
+	// Recursive Also load the migration files of every subdirectory of the base path (e.g. 2023/, 2024/), recorded
+	// by their path relative to it. The files of all the directories are ordered by version as a single change
+	// set, versions must be unique across them and OutOfOrder applies regardless of the directory.
+	Recursive bool
+
+	// AllowMissingFiles Let Migrate and Plan proceed when the file of an applied migration is no longer part of
+	// the change set (e.g. old migrations pruned on purpose), logging the missing files to Logger instead of
+	// failing. Validate no longer reports them either.
+	AllowMissingFiles bool
+
+	// Logger Receives a line for every change made outside of a migration run (e.g. checksums updated by
+	// Repair) and for warnings (e.g. missing files allowed by AllowMissingFiles). Nothing is logged when nil.
+	Logger *log.Logger
+}
+
+func (migrator Migrator) logf(format string, args ...interface{}) {
+	if migrator.Logger != nil {
+		migrator.Logger.Printf(format, args...)
+	}
+}
+
+// Reasons given to Migrator.OnSkip
+const (
+	SkipApplied        = "already applied"
+	SkipOutsideWindow  = "outside of version window"
+	SkipConditionFalse = "condition is false"
+	// SkipRequirementNotMet The server does not meet the requirement of the migration, which is recorded as a no-op
+	SkipRequirementNotMet = "server requirement not met"
+)
+
+func (migrator Migrator) inWindow(version int64) bool {
+	if migrator.FromVersion != 0 && version < migrator.FromVersion {
+		return false
+	}
+	if migrator.ToVersion != 0 && version > migrator.ToVersion {
+		return false
+	}
+	if migrator.TargetVersion != 0 && version > migrator.TargetVersion {
+		return false
+	}
+	return true
+}
+
+func (migrator Migrator) loadOptions() LoadOptions {
+	return LoadOptions{
+		ChecksumAlgorithm:    migrator.ChecksumAlgorithm,
+		NormalizeLineEndings: migrator.NormalizeLineEndings,
+		ChecksumMode:         migrator.ChecksumMode,
+		FilenamePattern:      migrator.FilenamePattern,
+		SemanticVersions:     migrator.SemanticVersions,
+		Recursive:            migrator.Recursive,
+	}
+}
+
+func (migrator Migrator) now() time.Time {
+	if migrator.Clock != nil {
+		return migrator.Clock().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (migrator Migrator) appliedBy() string {
+	if migrator.AppliedBy != "" {
+		return migrator.AppliedBy
+	}
+	return defaultAppliedBy()
+}
+
+// sortMigrations Sort applied migrations by version, breaking ties by insertion order (Id) so that rows
+// sharing a version are always verified in the same order
+func sortMigrations(migrations []Migration) {
+	sort.SliceStable(migrations, func(i, j int) bool {
+		if migrations[i].Version != migrations[j].Version {
+			return migrations[i].Version < migrations[j].Version
+		}
+		return migrations[i].Id < migrations[j].Id
+	})
 }
 
 func (migrator Migrator) verifyFsMigration(m *Migration, migrations []Migration, currentVersion int64) (verification_error, *Migration) {
 	for _, migration := range migrations {
-		if strings.EqualFold(m.File, migration.File) {
-			if m.Checksum == migration.Checksum {
+		if sameFile(m.File, migration.File) {
+			if checksumMatches(m, &migration) {
 				return err_migration_valid, &migration
 			}
+			if m.Repeatable {
+				return err_repeatable_changed, &migration
+			}
 			return err_migration_checksum_mismatch, &migration
 		}
 	}
 
-	if m.Version == currentVersion {
-		return err_migration_conflict, nil
+	if m.Repeatable {
+		return err_new_migration, nil
+	}
+
+	// the version was applied under another file name. Only versions that were not applied are compared with the
+	// current version, so back-filled migrations applied out of order verify like any other
+	for _, migration := range migrations {
+		if migration.Version == m.Version && !strings.HasPrefix(path.Base(migration.File), repeatable_prefix) {
+			return err_migration_conflict, nil
+		}
 	}
 	if m.Version < currentVersion {
 		if migrator.OutOfOrder {
-			return err_new_migration, nil
+			return err_new_out_of_order, nil
 		} else {
 			return err_migration_out_of_order, nil
 		}
@@ -139,28 +881,467 @@ func (migrator Migrator) verifyFsMigration(m *Migration, migrations []Migration,
 	return err_new_migration, nil
 }
 
+// checkContiguous Report the first version missing from the versioned migrations, which are sorted by version
+func (migrator Migrator) checkContiguous(migrations []Migration) error {
+	if migrator.SemanticVersions {
+		return errors.New("StrictContiguous does not support semantic versions")
+	}
+
+	expected := int64(1)
+	if migrator.BaseVersion != 0 {
+		expected = migrator.BaseVersion
+	}
+	if migrator.FromVersion != 0 {
+		expected = migrator.FromVersion
+	}
+	previous := ""
+	for _, m := range migrations {
+		if m.Repeatable || m.Version < expected {
+			continue
+		}
+		if m.Version != expected {
+			if previous == "" {
+				return errors.Errorf("missing migration version %d before %s: versions must be contiguous", expected, m.File)
+			}
+			return errors.Errorf("missing migration version %d between %s and %s: versions must be contiguous", expected, previous, m.File)
+		}
+		previous = m.File
+		expected++
+	}
+	return nil
+}
+
+// checkBaseVersion Reject migration files below BaseVersion
+func (migrator Migrator) checkBaseVersion(migrations []Migration) error {
+	if migrator.BaseVersion == 0 {
+		return nil
+	}
+	for _, m := range migrations {
+		if !m.Repeatable && m.Version < migrator.BaseVersion {
+			return errors.Errorf("%s: version %d is below the base version %d", m.File, m.Version, migrator.BaseVersion)
+		}
+	}
+	return nil
+}
+
+// missingFiles Files of the applied migrations that are not part of the change set
+func missingFiles(migrations []Migration, applied []Migration) []string {
+	var missing []string
+	for _, dbm := range applied {
+		if !hasFile(migrations, dbm.File) {
+			missing = append(missing, dbm.File)
+		}
+	}
+	return missing
+}
+
+// latestVersion Highest version of the applied migrations, 0 when none is versioned. Repeatable migrations are
+// recorded with version 0, and so may be a versioned migration.
+func latestVersion(migrations []Migration) int64 {
+	var latest int64
+	for _, m := range migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	return latest
+}
+
+func hasVersion(migrations []Migration, version int64) bool {
+	for _, m := range migrations {
+		if m.Version == version && !m.Repeatable {
+			return true
+		}
+	}
+	return false
+}
+
+func getMigrationInfo(ctx context.Context, ds DataSource) (*MigrationInfo, error) {
+	if cds, ok := ds.(ContextDataSource); ok {
+		return cds.GetMigrationInfoContext(ctx)
+	}
+	return ds.GetMigrationInfo()
+}
+
+func beginTransaction(ctx context.Context, ds DataSource) error {
+	if cds, ok := ds.(ContextDataSource); ok {
+		return cds.BeginTransactionContext(ctx)
+	}
+	return ds.BeginTransaction()
+}
+
+func applyMigration(ctx context.Context, ds DataSource, m *Migration) error {
+	if cds, ok := ds.(ContextDataSource); ok {
+		return cds.ApplyMigrationContext(ctx, m)
+	}
+	return ds.ApplyMigration(m)
+}
+
+// lock Acquire the data source's lock when UseLock is set. The returned function releases it.
+func (migrator Migrator) lock(ctx context.Context, ds DataSource) (func(), error) {
+	unlockTable := func() {}
+	if migrator.UseLockTable {
+		var err error
+		if unlockTable, err = migrator.lockTable(ctx, ds); err != nil {
+			return nil, err
+		}
+	}
+	if !migrator.UseLock {
+		return unlockTable, nil
+	}
+	locker, ok := ds.(Locker)
+	if !ok {
+		unlockTable()
+		return nil, errors.New("data source does not support locking")
+	}
+	if err := locker.Lock(ctx); err != nil {
+		unlockTable()
+		return nil, errors.Wrap(err, "failed to acquire migration lock")
+	}
+	return func() {
+		locker.Unlock()
+		unlockTable()
+	}, nil
+}
+
+func evaluateCondition(ctx context.Context, ds DataSource, m *Migration) (bool, error) {
+	evaluator, ok := ds.(ConditionEvaluator)
+	if !ok {
+		return false, &MigrationError{Err: errors.New("data source does not support conditional migrations"), Migration: m}
+	}
+	query, err := ExpandPlaceholders(m.Condition, m.Placeholders)
+	if err != nil {
+		return false, &MigrationError{Err: err, Migration: m}
+	}
+	ok, err = evaluator.EvaluateConditionContext(ctx, query)
+	if err != nil {
+		return false, &MigrationError{Err: errors.Wrap(err, "failed to evaluate condition"), Migration: m}
+	}
+	return ok, nil
+}
+
+// Migrate Migrate using context.Background()
 func (migrator Migrator) Migrate(ds DataSource) error {
-	var err error
-	var cfs fs.FS
-	var info *MigrationInfo
-	var openFiles []fs.File
+	return migrator.MigrateContext(context.Background(), ds)
+}
+
+// MigrateContext Apply pending migrations. The context is handed to every data source method
+// when the data source implements ContextDataSource.
+func (migrator Migrator) MigrateContext(ctx context.Context, ds DataSource) error {
+	_, err := migrator.MigrateResultContext(ctx, ds)
+	return err
+}
+
+// MigrateResult MigrateResultContext using context.Background()
+func (migrator Migrator) MigrateResult(ds DataSource) ([]*Migration, error) {
+	return migrator.MigrateResultContext(context.Background(), ds)
+}
+
+// MigrateResultContext Same as MigrateContext, also returning the migrations committed during this call, in the order
+// they were applied. When the run fails, the migrations committed before the failure are returned along with the
+// error (none in SingleTransaction mode, since the failure rolls everything back).
+func (migrator Migrator) MigrateResultContext(ctx context.Context, ds DataSource) ([]*Migration, error) {
+	started := time.Now()
+	audit := RunAudit{StartedAt: migrator.now(), AppliedBy: migrator.appliedBy()}
+
+	ctx, end := migrator.startSpan(ctx, SpanMigrate, nil)
+	runCtx := ctx
+	if migrator.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, migrator.RunTimeout)
+		defer cancel()
+	}
+
+	applied, err := migrator.retry(runCtx, ds, func() ([]*Migration, error) {
+		return migrator.run(runCtx, ds)
+	})
+	if err != nil && ctx.Err() == nil && runCtx.Err() == context.DeadlineExceeded {
+		err = RunTimeoutError{Timeout: migrator.RunTimeout, Completed: len(applied), Err: err}
+	}
+	var merr *MigrationError
+	if errors.As(err, &merr) {
+		merr.Applied = applied
+	}
+
+	if migrator.AuditSink != nil {
+		audit.FinishedAt = migrator.now()
+		audit.Duration = time.Since(started)
+		audit.Success = err == nil
+		if err != nil {
+			audit.Error = err.Error()
+		}
+		for _, m := range applied {
+			audit.Applied = append(audit.Applied, *m)
+		}
+		if aerr := migrator.AuditSink.RecordRun(audit); aerr != nil && err == nil {
+			err = errors.Wrap(aerr, "failed to record run audit")
+		}
+	}
+	migrator.emit(EventCompleted, nil, "", err)
+	end(err)
+	return applied, err
+}
+
+func (migrator Migrator) run(ctx context.Context, ds DataSource) ([]*Migration, error) {
+	var checker IntegrityChecker
+
+	if migrator.PostIntegrityCheck {
+		var ok bool
+		if checker, ok = ds.(IntegrityChecker); !ok {
+			return nil, errors.New("data source does not support integrity checks")
+		}
+	}
+
+	unlock, err := migrator.lock(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if migrator.DetectIncompleteRuns {
+		if err := migrator.detectIncomplete(ctx, ds); err != nil {
+			return nil, err
+		}
+	}
 
-	defer func() {
-		for _, f := range openFiles {
-			f.Close()
+	if migrator.StrictApply {
+		if err := migrator.validate(ctx, ds); err != nil {
+			return nil, errors.Wrap(err, "strict apply aborted")
 		}
-	}()
+	}
 
-	info, err = ds.GetMigrationInfo()
+	applied, err := migrator.migrate(ctx, ds)
+	if err != nil {
+		return applied, err
+	}
+
+	if checker != nil {
+		return applied, checker.CheckIntegrityContext(ctx)
+	}
+	return applied, nil
+}
+
+// migrate Apply pending migrations, returning the migrations that were committed
+func (migrator Migrator) migrate(ctx context.Context, ds DataSource) ([]*Migration, error) {
+	tx := transaction{ds: ds}
+	defer tx.rollback()
+
+	for {
+		err := migrator.migratePending(ctx, ds, &tx)
+		if errors.Is(err, errConcurrentRun) {
+			// start over from the migrations applied by the other run
+			tx.rollback()
+			migrator.logf("dsync: migrations were applied by a concurrent run, verifying again")
+			continue
+		}
+		if err != nil {
+			tx.rollback()
+			return tx.committed, err
+		}
+		break
+	}
+
+	if err := tx.commit(); err != nil {
+		return tx.committed, errors.Wrap(err, "commit failed")
+	}
+
+	return tx.committed, nil
+}
+
+// errConcurrentRun A migration about to be applied was applied by another run once the lock was acquired
+var errConcurrentRun = errors.New("migration applied by a concurrent run")
+
+// begin Begin the transaction m is applied in. When the transaction takes the lock (see TransactionLocker), fails
+// with errConcurrentRun if another run applied m before the lock was acquired.
+func (migrator Migrator) begin(ctx context.Context, ds DataSource, tx *transaction, m *Migration) error {
+	if tx.active {
+		return nil
+	}
+	if err := tx.begin(ctx); err != nil {
+		return err
+	}
+	if locker, ok := ds.(TransactionLocker); !migrator.UseLock || !ok || !locker.LocksTransactions() {
+		return nil
+	}
+	info, err := getMigrationInfo(ctx, ds)
+	if err != nil {
+		return err
+	}
+	for _, dbm := range info.Migrations {
+		if dbm.File == m.File && (!m.Repeatable || dbm.Checksum == m.Checksum) {
+			return errConcurrentRun
+		}
+	}
+	return nil
+}
+
+// migratePending Apply the pending migrations within tx
+func (migrator Migrator) migratePending(ctx context.Context, ds DataSource, tx *transaction) error {
+	if migrator.BeforeMigrate != nil {
+		if err := migrator.beforeMigrate(ctx, ds, tx); err != nil {
+			return err
+		}
+	}
+
+	skip := func(m *Migration, reason string) {
+		if migrator.OnSkip != nil {
+			migrator.OnSkip(ctx, m, reason)
+		}
+		migrator.emit(EventSkipped, m, reason, nil)
+	}
+	batchSize := migrator.batchSize(ds)
+	return migrator.walk(ctx, ds, func(m *Migration) error {
+		if m.NoTransaction {
+			// commit what has been applied so far, the migration runs on its own
+			if err := tx.commit(); err != nil {
+				return errors.Wrap(err, "commit failed")
+			}
+		} else if err := migrator.begin(ctx, ds, tx, m); err != nil {
+			return errors.Wrap(err, "migration failed.")
+		}
+		m.CreatedAt = migrator.now()
+		m.AppliedBy = migrator.appliedBy()
+		if m.NoOp {
+			if err := recordNoOp(ctx, ds, m); err != nil {
+				return errors.Wrap(err, "migration failed")
+			}
+			skip(m, SkipRequirementNotMet)
+			tx.applied(m)
+			if batchSize > 0 && len(tx.uncommitted) >= batchSize {
+				if err := tx.commit(); err != nil {
+					return errors.Wrap(err, "commit failed")
+				}
+			}
+			return nil
+		}
+		if migrator.BeforeEach != nil {
+			migrator.BeforeEach(ctx, m)
+		}
+		migrator.emit(EventStarted, m, "", nil)
+		spanCtx, end := migrator.startSpan(ctx, SpanMigration, migrationAttributes(m))
+		err := applyMigration(spanCtx, ds, m)
+		if err == nil && ctx.Err() != nil {
+			// do not commit a migration that completed after cancellation
+			err = &MigrationError{Err: ctx.Err(), Migration: m}
+		}
+		end(err)
+		if migrator.AfterEach != nil {
+			migrator.AfterEach(ctx, m, err)
+		}
+		if err != nil {
+			migrator.emit(EventFailed, m, "", err)
+			return errors.Wrap(err, "migration failed")
+		}
+		migrator.emit(EventApplied, m, "", nil)
+		tx.applied(m)
+		if m.OutOfOrder && migrator.OnOutOfOrder != nil {
+			migrator.OnOutOfOrder(ctx, m)
+		}
+		if batchSize > 0 && len(tx.uncommitted) >= batchSize {
+			if err := tx.commit(); err != nil {
+				return errors.Wrap(err, "commit failed")
+			}
+		}
+		return nil
+	}, skip)
+}
+
+// beforeMigrate Call BeforeMigrate with the pending migrations, within tx unless the first one runs outside of
+// any transaction
+func (migrator Migrator) beforeMigrate(ctx context.Context, ds DataSource, tx *transaction) error {
+	var pending []*Migration
+
+	err := migrator.walk(ctx, ds, func(m *Migration) error {
+		pending = append(pending, m)
+		return nil
+	}, func(*Migration, string) {})
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	if !pending[0].NoTransaction {
+		if err := migrator.begin(ctx, ds, tx, pending[0]); err != nil {
+			return errors.Wrap(err, "migration failed.")
+		}
+	}
+	return errors.Wrap(migrator.BeforeMigrate(ctx, ds, pending), "migration aborted by BeforeMigrate")
+}
+
+// Plan Returns the migrations Migrate would apply, in order, without applying them. Verification failures are
+// reported just like Migrate does. Each planned migration is rated with AssessLockRisk.
+func (migrator Migrator) Plan(ds DataSource) ([]*Migration, error) {
+	var plan []*Migration
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrator.walkReadOnly(context.Background(), ds, func(m *Migration) error {
+		script, err := ReadMigrationFile(cfs, filepath.Join(ds.GetPath(), m.File))
+		if err == nil {
+			script, err = io.ReadAll(UpSection(bytes.NewReader(script)))
+		}
+		if err != nil {
+			return &MigrationError{Err: err, Migration: m}
+		}
+		m.LockRisk, _ = AssessLockRisk(string(script))
+		plan = append(plan, m)
+		return nil
+	}, func(*Migration, string) {})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PendingCount Returns how many migrations Migrate would apply, without applying them or starting a transaction,
+// e.g. for a readiness probe. Unlike Plan, the scripts are not read. Verification failures are reported just like
+// Migrate does.
+func (migrator Migrator) PendingCount(ds DataSource) (int, error) {
+	var count int
+
+	err := migrator.walkReadOnly(context.Background(), ds, func(*Migration) error {
+		count++
+		return nil
+	}, func(*Migration, string) {})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// walk Verify every migration file of the change set against the applied migrations, calling pending for each
+// migration that has to be applied and skipped for the others. Verification failures abort the walk.
+func (migrator Migrator) walk(ctx context.Context, ds DataSource, pending func(m *Migration) error, skipped func(m *Migration, reason string)) error {
+	info, err := getMigrationInfo(ctx, ds)
 	if err != nil {
 		return err
 	}
+	return migrator.walkInfo(ctx, ds, info, pending, skipped)
+}
+
+// walkReadOnly walk without writing to the database, for the commands that apply nothing
+func (migrator Migrator) walkReadOnly(ctx context.Context, ds DataSource, pending func(m *Migration) error, skipped func(m *Migration, reason string)) error {
+	info, err := readMigrationInfo(ctx, ds)
+	if err != nil {
+		return err
+	}
+	return migrator.walkInfo(ctx, ds, info, pending, skipped)
+}
 
-	if len(info.Migrations) > 0 && info.Version == 0 {
+// walkInfo walk over the applied migrations of info
+func (migrator Migrator) walkInfo(ctx context.Context, ds DataSource, info *MigrationInfo, pending func(m *Migration) error, skipped func(m *Migration, reason string)) error {
+	var err error
+	var cfs fs.FS
+	// version Version of the server, queried when the first migration declaring a requirement is reached
+	var version *string
+
+	if latest := latestVersion(info.Migrations); info.Version != latest {
 		return errors.Errorf(
-			"current migration version %d does not correspond to number of migrations (%d).",
+			"current migration version %d does not correspond to the latest applied version (%d).",
 			info.Version,
-			len(info.Migrations),
+			latest,
 		)
 	}
 
@@ -170,54 +1351,127 @@ func (migrator Migrator) Migrate(ds DataSource) error {
 	}
 
 	// resort
-	sort.Slice(info.Migrations, func(i, j int) bool {
-		return info.Migrations[i].Version < info.Migrations[j].Version
-	})
+	sortMigrations(info.Migrations)
 
-	// get migration files
-	basepath := ds.GetPath()
-	entries, err := fs.ReadDir(cfs, basepath)
+	for _, dbm := range info.Migrations {
+		if !dbm.Success {
+			return errors.Errorf(
+				"%s: migration applied outside of a transaction was interrupted. Check whether it took effect, then "+
+					"delete its row from %s to apply it again, or set its Success column to true to keep it",
+				dbm.File,
+				info.TableName,
+			)
+		}
+	}
 
+	// get migration files
+	migrations, err := loadDataSourceMigrations(cfs, ds, migrator.loadOptions())
 	if err != nil {
-		return errors.Wrap(err, "error reading directory entries")
+		return err
 	}
-
-	if err := ds.BeginTransaction(); err != nil {
-		return errors.Wrap(err, "migration failed.")
+	if err := digestApplied(cfs, ds.GetPath(), migrations, info.Migrations, migrator.loadOptions()); err != nil {
+		return err
+	}
+	if missing := missingFiles(migrations, info.Migrations); len(missing) > 0 {
+		if !migrator.AllowMissingFiles {
+			return errors.Errorf(
+				"applied migration file(s) missing from the change set: %s. Restore them or set AllowMissingFiles",
+				strings.Join(missing, ", "),
+			)
+		}
+		migrator.logf("dsync: applied migration file(s) missing from the change set: %s", strings.Join(missing, ", "))
+	}
+	if migrator.TargetVersion != 0 && !hasVersion(migrations, migrator.TargetVersion) {
+		return errors.Errorf("target version %d does not correspond to any migration file", migrator.TargetVersion)
+	}
+	if err := migrator.checkBaseVersion(migrations); err != nil {
+		return err
+	}
+	if migrator.StrictContiguous {
+		if err := migrator.checkContiguous(migrations); err != nil {
+			return err
+		}
 	}
 
-	defer ds.EndTransaction()
-
-	for _, entry := range entries {
-		if entry.Type().IsRegular() && strings.ToLower(filepath.Ext(entry.Name())) == ".sql" {
-			m, err := ParseMigration(entry.Name())
+	for i := range migrations {
+		m := &migrations[i]
+		m.Placeholders = migrator.Placeholders
+		m.TemplateData = migrator.TemplateData
+		if err := ctx.Err(); err != nil {
+			return &MigrationError{Err: err, Migration: m}
+		}
+		e, dbm := migrator.verifyFsMigration(m, info.Migrations, info.Version)
+		if e != err_migration_valid && e != err_migration_checksum_mismatch && !m.Repeatable && !migrator.inWindow(m.Version) {
+			skipped(m, SkipOutsideWindow)
+			continue
+		}
+		if (e == err_new_migration || e == err_new_out_of_order || e == err_repeatable_changed) && m.Requirement != nil {
+			if version == nil {
+				v, err := serverVersion(ctx, ds, m)
+				if err != nil {
+					return err
+				}
+				version = &v
+			}
+			ok, err := m.Requirement.SatisfiedBy(*version)
 			if err != nil {
-				return err
+				return &MigrationError{Err: err, Migration: m}
+			}
+			if !ok && migrator.StrictRequirements {
+				return RequirementError{File: m.File, Requirement: *m.Requirement, ServerVersion: *version}
 			}
-			m.Checksum, err = HashFile(cfs, filepath.Join(basepath, entry.Name()))
+			m.NoOp = !ok
+		}
+		if (e == err_new_migration || e == err_new_out_of_order || e == err_migration_out_of_order || e == err_repeatable_changed) && m.Condition != "" && !m.NoOp {
+			ok, err := evaluateCondition(ctx, ds, m)
 			if err != nil {
 				return err
 			}
-			e, dbm := migrator.verifyFsMigration(m, info.Migrations, info.Version)
-			switch e {
-			case err_migration_checksum_mismatch:
-				return errors.Errorf("%s: migration file checksum conflict. expected %d, found %d", m.File, dbm.Checksum, m.Checksum)
-			case err_migration_valid:
-				// log.info("verified version %s", m.Name)
-			case err_new_migration:
-				if err := ds.ApplyMigration(m); err != nil {
-					return errors.Wrap(err, "migration failed")
-				}
-			case err_migration_conflict:
-				return errors.Errorf("%s: migration version %d already applied", m.File, m.Version)
-			case err_migration_out_of_order:
-				return errors.Errorf("%s: version %d is behind current version %d. Enable out of order to migrate this script", m.File, m.Version, info.Version)
-
+			if !ok {
+				// skipped migrations are not recorded and get evaluated again on the next run
+				skipped(m, SkipConditionFalse)
+				continue
+			}
+		}
+		switch e {
+		case err_migration_checksum_mismatch, err_migration_conflict, err_migration_out_of_order:
+			return verificationFailure(e, m, dbm, info.Version)
+		case err_migration_valid:
+			skipped(m, SkipApplied)
+		case err_new_migration:
+			if err := pending(m); err != nil {
+				return err
+			}
+		case err_new_out_of_order:
+			m.OutOfOrder = true
+			if err := pending(m); err != nil {
+				return err
+			}
+		case err_repeatable_changed:
+			// applied again, replacing the recorded row
+			m.Id = dbm.Id
+			if err := pending(m); err != nil {
+				return err
 			}
 		}
 	}
 
-	ds.SetTransactionSuccessful(true)
+	return nil
+}
 
+// verificationFailure Describe a failed verification of a migration file, nil if e is not a failure
+func verificationFailure(e verification_error, m *Migration, dbm *Migration, currentVersion int64) error {
+	switch e {
+	case err_migration_checksum_mismatch:
+		mismatch := ChecksumMismatchError{File: m.File, Expected: dbm.Checksum, Actual: m.Checksum, ExpectedSize: dbm.Size, ActualSize: m.Size}
+		if m.Digest != "" && dbm.Digest != "" {
+			mismatch.ExpectedDigest, mismatch.ActualDigest = dbm.Digest, m.Digest
+		}
+		return mismatch
+	case err_migration_conflict:
+		return ConflictError{File: m.File, Version: m.Version}
+	case err_migration_out_of_order:
+		return OutOfOrderError{File: m.File, Version: m.Version, CurrentVersion: currentVersion}
+	}
 	return nil
 }
@@ -0,0 +1,77 @@
+package dsync
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Environment variables read by ConfigFromEnv and MigratorFromEnv
+const (
+	EnvDriver     = "DSYNC_DRIVER"
+	EnvDSN        = "DSYNC_DSN"
+	EnvPath       = "DSYNC_PATH"
+	EnvTable      = "DSYNC_TABLE"
+	EnvOutOfOrder = "DSYNC_OUT_OF_ORDER"
+)
+
+// DEFAULT_ENV_PATH Basepath used by ConfigFromEnv when DSYNC_PATH is not set
+const DEFAULT_ENV_PATH = "migrations"
+
+// ConfigFromEnv Read the migration settings from the environment, e.g. in containers, keeping the DSN out of the
+// code:
+//
+//	DSYNC_DRIVER        required, name of the driver, lower cased, e.g. postgres, mysql, sqlite or mssql
+//	DSYNC_DSN           required, data source name of the database
+//	DSYNC_PATH          directory of the migration files within the FileSystem, defaults to "migrations"
+//	DSYNC_TABLE         name of the migration table, defaults to DEFAULT_TABLE_NAME
+//
+// The FileSystem of the returned Config is left for the caller to set, typically to an embed.FS, before passing it
+// to the data source of the returned driver with the returned dsn. The driver name is not checked: the caller
+// picks the data source it names, and rejects the others. Missing required variables are all named in the
+// returned error. DSYNC_OUT_OF_ORDER is read by MigratorFromEnv.
+func ConfigFromEnv() (cfg *Config, dsn string, driver string, err error) {
+	var missing []string
+
+	driver = strings.ToLower(strings.TrimSpace(os.Getenv(EnvDriver)))
+	if driver == "" {
+		missing = append(missing, EnvDriver)
+	}
+	dsn = os.Getenv(EnvDSN)
+	if strings.TrimSpace(dsn) == "" {
+		missing = append(missing, EnvDSN)
+	}
+	if len(missing) > 0 {
+		return nil, "", "", errors.Errorf("missing environment variable(s) %s", strings.Join(missing, ", "))
+	}
+
+	cfg = &Config{
+		Basepath:  DEFAULT_ENV_PATH,
+		TableName: strings.TrimSpace(os.Getenv(EnvTable)),
+	}
+	if dir := strings.TrimSpace(os.Getenv(EnvPath)); dir != "" {
+		cfg.Basepath = dir
+	}
+	if len(cfg.TableName) > 0 && !table_name_pattern.MatchString(cfg.TableName) {
+		return nil, "", "", errors.Errorf("invalid %s %q: only letters, digits and underscores are allowed, optionally qualified as schema.table", EnvTable, cfg.TableName)
+	}
+	return cfg, dsn, driver, nil
+}
+
+// MigratorFromEnv Read the settings of the Migrator from the environment, next to ConfigFromEnv:
+//
+//	DSYNC_OUT_OF_ORDER  boolean (true, 1, false, 0...), sets Migrator.OutOfOrder
+func MigratorFromEnv() (Migrator, error) {
+	var migrator Migrator
+
+	if value := strings.TrimSpace(os.Getenv(EnvOutOfOrder)); value != "" {
+		outOfOrder, err := strconv.ParseBool(value)
+		if err != nil {
+			return migrator, errors.Errorf("invalid %s %q: expected a boolean", EnvOutOfOrder, value)
+		}
+		migrator.OutOfOrder = outOfOrder
+	}
+	return migrator, nil
+}
@@ -0,0 +1,61 @@
+package dsync
+
+import "time"
+
+// EventType Kind of a MigrationEvent
+type EventType int
+
+const (
+	// EventStarted A migration is about to be applied
+	EventStarted EventType = iota
+	// EventApplied A migration has been applied. It is committed with the rest of its transaction.
+	EventApplied
+	// EventSkipped A migration file is not applied, see MigrationEvent.Reason
+	EventSkipped
+	// EventFailed A migration failed, see MigrationEvent.Err
+	EventFailed
+	// EventCompleted The Migrate call returned, with MigrationEvent.Err set if it failed. Always the last event of a
+	// call, it carries no migration.
+	EventCompleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStarted:
+		return "started"
+	case EventApplied:
+		return "applied"
+	case EventSkipped:
+		return "skipped"
+	case EventFailed:
+		return "failed"
+	case EventCompleted:
+		return "completed"
+	default:
+		return "unknown"
+	}
+}
+
+// MigrationEvent Progress of a Migrate call, sent to Migrator.Events
+type MigrationEvent struct {
+	Type EventType
+	// Migration Migration the event is about, nil for EventCompleted
+	Migration *Migration
+	// Reason Why the migration was skipped (SkipApplied, SkipOutsideWindow, SkipConditionFalse), for EventSkipped
+	Reason string
+	// Err Error of EventFailed and EventCompleted, nil on success
+	Err error
+	// Time When the event happened, see Migrator.Clock
+	Time time.Time
+}
+
+// emit Send an event to Events without blocking, dropping it when the channel is not ready to receive it
+func (migrator Migrator) emit(t EventType, m *Migration, reason string, err error) {
+	if migrator.Events == nil {
+		return
+	}
+	select {
+	case migrator.Events <- MigrationEvent{Type: t, Migration: m, Reason: reason, Err: err, Time: migrator.now()}:
+	default:
+	}
+}
@@ -0,0 +1,159 @@
+package dsync
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HistorySchema Identifies the JSON layout written by ExportHistory. It changes whenever the layout changes in
+// an incompatible way.
+const HistorySchema = "dsync/history/v1"
+
+// History Migration history as exported by ExportHistory
+type History struct {
+	Schema     string         `json:"schema"`
+	Table      string         `json:"table"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Migrations []HistoryEntry `json:"migrations"`
+}
+
+// HistoryEntry An applied migration, as exported by ExportHistory
+type HistoryEntry struct {
+	Version      int64     `json:"version"`
+	VersionLabel string    `json:"version_label,omitempty"`
+	Name         string    `json:"name"`
+	File         string    `json:"file"`
+	Checksum     int64     `json:"checksum"`
+	Digest       string    `json:"digest,omitempty"`
+	Algorithm    string    `json:"algorithm"`
+	CreatedAt    time.Time `json:"created_at"`
+	Success      bool      `json:"success"`
+	DurationMs   int64     `json:"duration_ms"`
+	AppliedBy    string    `json:"applied_by,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	NoOp         bool      `json:"no_op,omitempty"`
+}
+
+// HistoryMismatchError Every difference found by ImportHistory between an exported history and the database
+type HistoryMismatchError struct {
+	Errors []error
+}
+
+func (e HistoryMismatchError) Error() string {
+	var builder strings.Builder
+
+	builder.WriteString(strconv.Itoa(len(e.Errors)))
+	builder.WriteString(" difference(s) with the exported history")
+	for _, err := range e.Errors {
+		builder.WriteString("; ")
+		builder.WriteString(err.Error())
+	}
+	return builder.String()
+}
+
+// ExportHistory Write the applied migrations to w as a JSON History document, in ascending version order, e.g. to
+// keep a snapshot for compliance or to compare environments with ImportHistory
+func (migrator Migrator) ExportHistory(ds DataSource, w io.Writer) error {
+	info, err := readMigrationInfo(context.Background(), ds)
+	if err != nil {
+		return err
+	}
+	sortMigrations(info.Migrations)
+
+	history := History{
+		Schema:     HistorySchema,
+		Table:      info.TableName,
+		ExportedAt: migrator.now(),
+		Migrations: []HistoryEntry{},
+	}
+	for _, m := range info.Migrations {
+		history.Migrations = append(history.Migrations, HistoryEntry{
+			Version:      m.Version,
+			VersionLabel: m.VersionLabel,
+			Name:         m.Name,
+			File:         m.File,
+			Checksum:     m.Checksum,
+			Digest:       m.Digest,
+			Algorithm:    recordedAlgorithm(&m).String(),
+			CreatedAt:    m.CreatedAt.UTC(),
+			Success:      m.Success,
+			DurationMs:   m.Duration.Milliseconds(),
+			AppliedBy:    m.AppliedBy,
+			Size:         m.Size,
+			NoOp:         m.NoOp,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(history), "failed to export migration history")
+}
+
+// ImportHistory Compare a History document written by ExportHistory with the migrations applied to ds. Nothing
+// is applied or written. Migrations missing on either side and migrations recorded with a different version or
+// checksum are reported in a single HistoryMismatchError.
+func (migrator Migrator) ImportHistory(ds DataSource, r io.Reader) error {
+	var history History
+	var problems []error
+
+	if err := json.NewDecoder(r).Decode(&history); err != nil {
+		return errors.Wrap(err, "failed to read migration history")
+	}
+	if history.Schema != HistorySchema {
+		return errors.Errorf("unsupported migration history schema %q, expected %q", history.Schema, HistorySchema)
+	}
+
+	info, err := readMigrationInfo(context.Background(), ds)
+	if err != nil {
+		return err
+	}
+	sortMigrations(info.Migrations)
+
+	for _, entry := range history.Migrations {
+		dbm := findApplied(info.Migrations, entry.File)
+		switch {
+		case dbm == nil:
+			problems = append(problems, errors.Errorf("%s: exported but not applied", entry.File))
+		case dbm.Version != entry.Version:
+			problems = append(problems, errors.Errorf("%s: exported with version %d, applied with version %d", entry.File, entry.Version, dbm.Version))
+		case dbm.Checksum != entry.Checksum || dbm.Digest != entry.Digest:
+			problems = append(problems, errors.Errorf("%s: exported and applied checksums differ", entry.File))
+		case dbm.Success != entry.Success:
+			problems = append(problems, errors.Errorf("%s: exported and applied completion states differ", entry.File))
+		}
+	}
+	for _, dbm := range info.Migrations {
+		if !exported(history.Migrations, dbm.File) {
+			problems = append(problems, errors.Errorf("%s: applied but not exported", dbm.File))
+		}
+	}
+
+	if len(problems) > 0 {
+		return HistoryMismatchError{Errors: problems}
+	}
+	return nil
+}
+
+func findApplied(applied []Migration, file string) *Migration {
+	for i := range applied {
+		if strings.EqualFold(applied[i].File, file) {
+			return &applied[i]
+		}
+	}
+	return nil
+}
+
+func exported(entries []HistoryEntry, file string) bool {
+	for _, entry := range entries {
+		if strings.EqualFold(entry.File, file) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package dsync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// default_extension Extension of migration files unless Config.Extensions says otherwise
+const default_extension = ".sql"
+
+// down_infix Inserted before the extension of a migration file to name its down script
+const down_infix = ".down"
+
+// ExtensionDataSource is implemented by data sources whose migration files may use other extensions than .sql
+// (see Config.Extensions)
+type ExtensionDataSource interface {
+	// GetExtensions Returns the extensions of migration files, nil for the default .sql
+	GetExtensions() []string
+}
+
+// dataSourceExtensions Extensions of the migration files of ds, nil for the default
+func dataSourceExtensions(ds DataSource) []string {
+	if eds, ok := ds.(ExtensionDataSource); ok {
+		return eds.GetExtensions()
+	}
+	return nil
+}
+
+// migrationExtension Returns the extension of a migration file as written, the longest of extensions (.sql when
+// empty) it ends with ignoring case and the .gz suffix, "" if none
+func migrationExtension(name string, extensions []string) string {
+	name = trimCompressed(name)
+	if len(extensions) == 0 {
+		extensions = []string{default_extension}
+	}
+
+	longest := 0
+	for _, ext := range extensions {
+		if len(ext) > longest && len(ext) <= len(name) && strings.EqualFold(name[len(name)-len(ext):], ext) {
+			longest = len(ext)
+		}
+	}
+	return name[len(name)-longest:]
+}
+
+// isMigrationFile Migration files end with one of extensions, optionally followed by .gz
+func isMigrationFile(name string, extensions []string) bool {
+	return migrationExtension(name, extensions) != ""
+}
+
+// isDownFile Down scripts are migration files whose extension is preceded by .down
+func isDownFile(name string, extensions []string) bool {
+	ext := migrationExtension(name, extensions)
+	if ext == "" {
+		return false
+	}
+	name = trimCompressed(name)
+	return strings.HasSuffix(strings.ToLower(name[:len(name)-len(ext)]), down_infix)
+}
+
+// downFile DownFile for migration files with the given extensions
+func downFile(file string, extensions []string) string {
+	suffix := file[len(trimCompressed(file)):]
+	file = trimCompressed(file)
+	ext := migrationExtension(file, extensions)
+	if ext == "" {
+		ext = filepath.Ext(file)
+	}
+	return file[:len(file)-len(ext)] + down_infix + ext + suffix
+}
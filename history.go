@@ -0,0 +1,39 @@
+package dsync
+
+import (
+	"context"
+	"time"
+)
+
+// AppliedSince Returns the applied migrations recorded at or after the given time, in ascending version order.
+// Timestamps are compared in UTC.
+func (migrator Migrator) AppliedSince(ds DataSource, since time.Time) ([]Migration, error) {
+	return appliedWhere(ds, func(m *Migration) bool {
+		return !m.CreatedAt.UTC().Before(since.UTC())
+	})
+}
+
+// AppliedSinceVersion Returns the applied migrations whose version is greater than the given version, in
+// ascending version order.
+func (migrator Migrator) AppliedSinceVersion(ds DataSource, version int64) ([]Migration, error) {
+	return appliedWhere(ds, func(m *Migration) bool {
+		return m.Version > version
+	})
+}
+
+func appliedWhere(ds DataSource, include func(m *Migration) bool) ([]Migration, error) {
+	var migrations []Migration
+
+	info, err := readMigrationInfo(context.Background(), ds)
+	if err != nil {
+		return nil, err
+	}
+
+	sortMigrations(info.Migrations)
+	for i := range info.Migrations {
+		if include(&info.Migrations[i]) {
+			migrations = append(migrations, info.Migrations[i])
+		}
+	}
+	return migrations, nil
+}
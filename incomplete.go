@@ -0,0 +1,132 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrIncompleteRun The schema reflects migrations the migration table does not record
+var ErrIncompleteRun = errors.New("incomplete previous run detected")
+
+// SchemaTableChecker is implemented by data sources able to tell whether a table exists, see DetectIncomplete
+type SchemaTableChecker interface {
+	// HasTableContext Reports whether the table exists, given its unquoted, optionally schema qualified, name
+	HasTableContext(ctx context.Context, name string) (bool, error)
+}
+
+// IncompleteMigration A pending migration whose tables already exist
+type IncompleteMigration struct {
+	File   string
+	Tables []string
+}
+
+// IncompleteRunError Pending migrations whose effects are already in the schema, e.g. because a run was killed
+// after MySQL committed their DDL but before they were recorded. Matches ErrIncompleteRun.
+type IncompleteRunError struct {
+	Migrations []IncompleteMigration
+}
+
+func (e IncompleteRunError) Error() string {
+	var builder strings.Builder
+	builder.WriteString(ErrIncompleteRun.Error())
+	builder.WriteString(": the schema already has the tables of unrecorded migrations")
+	for _, m := range e.Migrations {
+		builder.WriteString("\n  " + m.File + ": " + strings.Join(m.Tables, ", "))
+	}
+	builder.WriteString("\nbefore migrating again, revert their partial changes by hand, or complete them and record the migrations as" +
+		" applied with Migrator.Baseline (while the migration table has no records); then run Migrator.Repair if their" +
+		" files were edited to match the schema")
+	return builder.String()
+}
+
+func (e IncompleteRunError) Unwrap() error {
+	return ErrIncompleteRun
+}
+
+// create_table_pattern CREATE TABLE statements failing when the table exists, capturing the table name
+var create_table_pattern = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:(?:GLOBAL|LOCAL)\s+)?(?:TEMP(?:ORARY)?\s+|UNLOGGED\s+)?TABLE\s+(IF\s+NOT\s+EXISTS\s+)?([^\s(;]+)`)
+
+// DetectIncomplete Look for pending migrations whose tables already exist, which happens when a previous run was
+// interrupted after its DDL was committed but before the migrations were recorded (MySQL commits DDL implicitly).
+// This is a heuristic: only the tables created by CREATE TABLE statements without IF NOT EXISTS are checked, other
+// changes go unnoticed. Returns an IncompleteRunError naming the migrations and tables found, nil otherwise. The
+// data source must implement SchemaTableChecker.
+func (migrator Migrator) DetectIncomplete(ds DataSource) error {
+	return migrator.detectIncomplete(context.Background(), ds)
+}
+
+// detectIncomplete DetectIncomplete using the context of the run
+func (migrator Migrator) detectIncomplete(ctx context.Context, ds DataSource) error {
+	checker, ok := ds.(SchemaTableChecker)
+	if !ok {
+		return errors.New("data source does not support incomplete run detection")
+	}
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return err
+	}
+
+	var incomplete []IncompleteMigration
+	err = migrator.walkReadOnly(ctx, ds, func(m *Migration) error {
+		if m.NoOp {
+			return nil
+		}
+		script, err := ReadMigrationFile(cfs, filepath.Join(ds.GetPath(), m.File))
+		if err == nil {
+			script, err = io.ReadAll(UpSection(bytes.NewReader(script)))
+		}
+		if err != nil {
+			return &MigrationError{Err: err, Migration: m}
+		}
+		var existing []string
+		for _, table := range createdTables(string(script), m.Placeholders) {
+			exists, err := checker.HasTableContext(ctx, table)
+			if err != nil {
+				return &MigrationError{Err: errors.Wrap(err, "failed to look up table "+table), Migration: m}
+			}
+			if exists {
+				existing = append(existing, table)
+			}
+		}
+		if len(existing) > 0 {
+			incomplete = append(incomplete, IncompleteMigration{File: m.File, Tables: existing})
+		}
+		return nil
+	}, func(*Migration, string) {})
+	if err != nil {
+		return err
+	}
+	if len(incomplete) > 0 {
+		return IncompleteRunError{Migrations: incomplete}
+	}
+	return nil
+}
+
+// createdTables Unquoted names of the tables created by the CREATE TABLE statements of a script that fail when the
+// table exists
+func createdTables(script string, placeholders map[string]string) []string {
+	var tables []string
+	script = lineComment.ReplaceAllString(script, "")
+	for _, statement := range SplitStatements(script) {
+		if expanded, err := ExpandPlaceholders(statement, placeholders); err == nil {
+			statement = expanded
+		}
+		match := create_table_pattern.FindStringSubmatch(statement)
+		if match == nil || match[1] != "" {
+			continue
+		}
+		parts := strings.Split(match[2], ".")
+		for i := range parts {
+			parts[i] = strings.Trim(parts[i], "\"`[]")
+		}
+		tables = append(tables, strings.Join(parts, "."))
+	}
+	return tables
+}
@@ -0,0 +1,94 @@
+package dsync
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rules reported in LintIssue.Rule
+const (
+	// LintRead The directory could not be read
+	LintRead = "read"
+	// LintGrammar The file has a migration extension but its name does not parse (see ParseMigration)
+	LintGrammar = "grammar"
+	// LintVersionWidth The version has fewer digits than LintRules.VersionWidth
+	LintVersionWidth = "version-width"
+	// LintName The name does not match LintRules.NamePattern
+	LintName = "name"
+	// LintExtension The extension is not one of LintRules.Extensions
+	LintExtension = "extension"
+)
+
+// LintRules House style checked by LintFilenames on top of the grammar of ParseMigration. Zero values disable
+// their rule.
+type LintRules struct {
+	// VersionWidth Minimum number of digits of versions, e.g. 4 for 0001__init.sql
+	VersionWidth int
+	// NamePattern Pattern the name must match, without its extensions, e.g. `^[a-z0-9]+(_[a-z0-9]+)*$` for
+	// lowercase snake_case
+	NamePattern *regexp.Regexp
+	// Extensions Extensions migration files must have, matched ignoring case and optionally followed by .gz
+	Extensions []string
+}
+
+// LintIssue A file breaking a rule of LintFilenames
+type LintIssue struct {
+	// File Path of the file within the file system
+	File string
+	// Rule Rule broken, one of LintGrammar, LintVersionWidth, LintName, LintExtension or LintRead
+	Rule    string
+	Message string
+}
+
+// String Formats the issue as "<file>: <rule>: <message>", the layout most CI annotation parsers understand
+func (i LintIssue) String() string {
+	return i.File + ": " + i.Rule + ": " + i.Message
+}
+
+// LintFilenames Check the names of the migration files of a directory against rules, without a database, e.g. in
+// CI. Files whose name parses as a migration are checked against every rule, files with a .sql extension (or one
+// of rules.Extensions) that do not parse are reported as LintGrammar, and any other file is ignored. Issues are
+// returned in file name order.
+func LintFilenames(fsys fs.FS, basepath string, rules LintRules) []LintIssue {
+	var issues []LintIssue
+
+	entries, err := fs.ReadDir(fsys, basepath)
+	if err != nil {
+		return []LintIssue{{File: basepath, Rule: LintRead, Message: err.Error()}}
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		file := path.Join(basepath, name)
+		issue := func(rule string, message string) {
+			issues = append(issues, LintIssue{File: file, Rule: rule, Message: message})
+		}
+
+		m, err := ParseMigration(name)
+		if err != nil {
+			if isMigrationFile(name, nil) || (len(rules.Extensions) > 0 && isMigrationFile(name, rules.Extensions)) {
+				issue(LintGrammar, err.Error())
+			}
+			continue
+		}
+
+		if !m.Repeatable && rules.VersionWidth > 0 {
+			if digits := name[:strings.Index(name, migration_separator)]; len(digits) < rules.VersionWidth {
+				issue(LintVersionWidth, "version "+digits+" has fewer than "+strconv.Itoa(rules.VersionWidth)+" digits")
+			}
+		}
+		if stem := strings.SplitN(m.Name, ".", 2)[0]; rules.NamePattern != nil && !rules.NamePattern.MatchString(stem) {
+			issue(LintName, "name "+strconv.Quote(stem)+" does not match "+rules.NamePattern.String())
+		}
+		if len(rules.Extensions) > 0 && !isMigrationFile(name, rules.Extensions) {
+			issue(LintExtension, "extension must be one of "+strings.Join(rules.Extensions, ", "))
+		}
+	}
+	return issues
+}
@@ -0,0 +1,218 @@
+package dsync
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LoadOptions Options of LoadMigrations
+type LoadOptions struct {
+	// SkipInvalid Ignore .sql files whose name does not follow the naming convention instead of reporting them
+	SkipInvalid bool
+
+	// ChecksumAlgorithm Also compute Migration.Digest when set to SHA256
+	ChecksumAlgorithm ChecksumAlgorithm
+
+	// NormalizeLineEndings Strip carriage returns before computing checksums (see NormalizeLineEndings)
+	NormalizeLineEndings bool
+
+	// FilenamePattern Parse file names with ParseMigrationPattern instead of ParseMigration. Files that do not
+	// match the pattern are skipped.
+	FilenamePattern *regexp.Regexp
+
+	// Extensions Extensions of migration files, matched ignoring case. Defaults to .sql.
+	Extensions []string
+
+	// ChecksumMode Hash the files as written (Raw) or after NormalizeSQL (Normalized)
+	ChecksumMode ChecksumMode
+
+	// SemanticVersions Parse versions as dotted semantic versions (see SemanticVersion)
+	SemanticVersions bool
+
+	// Recursive Also load the files of every subdirectory, Migration.File being their path relative to the
+	// directory
+	Recursive bool
+}
+
+func (opts LoadOptions) parse(filename string) (*Migration, error) {
+	if opts.FilenamePattern != nil {
+		return parseMigrationPattern(opts.FilenamePattern, filename, opts.SemanticVersions)
+	}
+	return parseMigration(filename, opts.SemanticVersions)
+}
+
+// LoadError Every problem found by LoadMigrations
+type LoadError struct {
+	Errors []error
+}
+
+func (e LoadError) Error() string {
+	var builder strings.Builder
+
+	builder.WriteString(strconv.Itoa(len(e.Errors)))
+	builder.WriteString(" problem(s) loading migrations")
+	for _, err := range e.Errors {
+		builder.WriteString("; ")
+		builder.WriteString(err.Error())
+	}
+	return builder.String()
+}
+
+// LoadMigrations Enumerate the .sql (or LoadOptions.Extensions) and .sql.gz files (except down scripts) in basepath, parse their names and directives, compute their checksums
+// and return them sorted by version, followed by the repeatable migrations sorted by file name. The directory is validated as a whole (unparseable names, duplicate
+// versions, unreadable files) and every problem is reported in a single LoadError. No database is involved.
+func LoadMigrations(fsys fs.FS, basepath string, opts LoadOptions) ([]Migration, error) {
+	return loadMigrations(fsys, []string{basepath}, false, opts)
+}
+
+// LoadMigrationDirs Load the migrations of several directories like LoadMigrations, merged into a single change set.
+// Migration.File is the path of each file relative to the root of fsys and versions must be unique across the
+// directories.
+func LoadMigrationDirs(fsys fs.FS, basepaths []string, opts LoadOptions) ([]Migration, error) {
+	return loadMigrations(fsys, basepaths, true, opts)
+}
+
+// VerifyDirectory Check the integrity of a migration directory without a database, e.g. from a pre-commit hook:
+// every .sql file must follow the naming convention, be readable and have a unique version. Every problem is
+// reported in a single LoadError. Use LoadMigrations for other file name patterns or extensions.
+func VerifyDirectory(fsys fs.FS, basepath string) error {
+	_, err := LoadMigrations(fsys, basepath, LoadOptions{})
+	return err
+}
+
+// loadDataSourceMigrations Load the change set of ds, from every directory of a MultiPathDataSource and with the
+// extensions of an ExtensionDataSource
+func loadDataSourceMigrations(fsys fs.FS, ds DataSource, opts LoadOptions) ([]Migration, error) {
+	if extensions := dataSourceExtensions(ds); len(extensions) > 0 {
+		opts.Extensions = extensions
+	}
+	if mp, ok := ds.(MultiPathDataSource); ok && len(mp.GetPaths()) > 0 {
+		return LoadMigrationDirs(fsys, mp.GetPaths(), opts)
+	}
+	return LoadMigrations(fsys, ds.GetPath(), opts)
+}
+
+// loadMigrations Load the migrations of every directory, naming their files after their path within fsys when
+// qualified
+func loadMigrations(fsys fs.FS, basepaths []string, qualified bool, opts LoadOptions) ([]Migration, error) {
+	var problems []error
+	var migrations []Migration
+
+	if opts.FilenamePattern != nil {
+		if err := validateFilenamePattern(opts.FilenamePattern); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, basepath := range basepaths {
+		files, err := migrationFiles(fsys, basepath, opts.Recursive)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading directory entries")
+		}
+
+		for _, file := range files {
+			dir, name := path.Split(file)
+			if !isMigrationFile(name, opts.Extensions) || isDownFile(name, opts.Extensions) {
+				continue
+			}
+			m, err := opts.parse(name)
+			if err != nil {
+				if !opts.SkipInvalid {
+					problems = append(problems, err)
+				}
+				continue
+			}
+			if m == nil {
+				// does not match FilenamePattern
+				continue
+			}
+			m.File = path.Join(dir, m.File)
+			if qualified {
+				m.File = path.Join(basepath, m.File)
+			}
+			filename := filepath.Join(basepath, file)
+			if err = readDirectives(fsys, filename, m); err != nil {
+				problems = append(problems, err)
+				continue
+			}
+			m.Algorithm = opts.ChecksumAlgorithm
+			blobs := make([]string, 0, len(m.Blobs))
+			for _, blob := range m.Blobs {
+				blobs = append(blobs, path.Join(basepath, dir, blob.File))
+			}
+			if m.Checksum, m.Digest, m.Size, err = checksums(fsys, filename, blobs, opts); err != nil {
+				problems = append(problems, errors.Wrap(err, m.File))
+				continue
+			}
+			migrations = append(migrations, *m)
+		}
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		if migrations[i].Repeatable != migrations[j].Repeatable {
+			return !migrations[i].Repeatable
+		}
+		if migrations[i].Version != migrations[j].Version {
+			return migrations[i].Version < migrations[j].Version
+		}
+		return migrations[i].File < migrations[j].File
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version && !migrations[i].Repeatable {
+			problems = append(problems, errors.Errorf(
+				"duplicate migration version %d: %s and %s",
+				migrations[i].Version,
+				migrations[i-1].File,
+				migrations[i].File,
+			))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, LoadError{Errors: problems}
+	}
+	return migrations, nil
+}
+
+// migrationFiles List the regular files of basepath, and of its subdirectories when recursive, by their path
+// relative to basepath
+func migrationFiles(fsys fs.FS, basepath string, recursive bool) ([]string, error) {
+	var files []string
+
+	if !recursive {
+		entries, err := fs.ReadDir(fsys, basepath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, entry.Name())
+			}
+		}
+		return files, nil
+	}
+
+	root := path.Clean(basepath)
+	err := fs.WalkDir(fsys, root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if root != "." {
+			name = strings.TrimPrefix(name, root+"/")
+		}
+		files = append(files, name)
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,309 @@
+package dsync_test
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/SharkFourSix/dsync"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010__c.sql": {Data: []byte(`CREATE TABLE c (id INTEGER);`)},
+		"migrations/0002__b.sql": {Data: []byte(`-- dsync:transactional=false
+VACUUM;`)},
+		"migrations/0001__a.sql":     {Data: []byte(`CREATE TABLE a (id INTEGER);`)},
+		"migrations/README.md":       {Data: []byte(`not a migration`)},
+		"migrations/nested/0003.sql": {Data: []byte(`ignored`)},
+	}
+
+	migrations, err := dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("expected 3 migrations, got %d", len(migrations))
+	}
+	for i, version := range []int64{1, 2, 10} {
+		if migrations[i].Version != version {
+			t.Fatalf("expected version %d at %d, got %d", version, i, migrations[i].Version)
+		}
+		if migrations[i].Checksum == 0 {
+			t.Fatalf("%s: missing checksum", migrations[i].File)
+		}
+	}
+	if !migrations[1].NoTransaction {
+		t.Fatal("expected directives to be parsed")
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001__a.sql":      {Data: []byte(`SELECT 1;`)},
+		"migrations/0001__b.sql":      {Data: []byte(`SELECT 1;`)},
+		"migrations/init.sql":         {Data: []byte(`SELECT 1;`)},
+		"migrations/0002_bad_sep.sql": {Data: []byte(`SELECT 1;`)},
+		"migrations/0003__unknown.sql": {Data: []byte(`-- dsync:frobnicate
+SELECT 1;`)},
+	}
+
+	_, err := dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{})
+
+	var le dsync.LoadError
+	if !errors.As(err, &le) {
+		t.Fatalf("expected LoadError, got %v", err)
+	}
+	if len(le.Errors) != 4 {
+		t.Fatalf("expected 4 problems, got %d: %v", len(le.Errors), le)
+	}
+	if !strings.Contains(err.Error(), "0001__a.sql and 0001__b.sql") {
+		t.Fatalf("expected duplicate versions to name both files: %v", err)
+	}
+
+	// invalid names can be skipped, the remaining problems are still reported
+	_, err = dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{SkipInvalid: true})
+	if !errors.As(err, &le) || len(le.Errors) != 2 {
+		t.Fatalf("expected 2 problems, got %v", err)
+	}
+
+	if _, err := dsync.LoadMigrations(fsys, "missing", dsync.LoadOptions{}); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
+
+func TestLoadMigrationsNormalizeLineEndings(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lf/0001__a.sql":   {Data: []byte("CREATE TABLE a (\n\tid INTEGER\n);\n")},
+		"crlf/0001__a.sql": {Data: []byte("CREATE TABLE a (\r\n\tid INTEGER\r\n);\r\n")},
+	}
+
+	for _, normalize := range []bool{false, true} {
+		opts := dsync.LoadOptions{ChecksumAlgorithm: dsync.SHA256, NormalizeLineEndings: normalize}
+		lf, err := dsync.LoadMigrations(fsys, "lf", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crlf, err := dsync.LoadMigrations(fsys, "crlf", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		same := lf[0].Checksum == crlf[0].Checksum && lf[0].Digest == crlf[0].Digest
+		if same != normalize {
+			t.Fatalf("normalize=%v: expected equal checksums to be %v, got %d/%s and %d/%s",
+				normalize, normalize, lf[0].Checksum, lf[0].Digest, crlf[0].Checksum, crlf[0].Digest)
+		}
+	}
+}
+
+func TestLoadMigrationsFilenamePattern(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/V2__b.sql":    {Data: []byte(`CREATE TABLE b (id INTEGER);`)},
+		"migrations/V10__c.sql":   {Data: []byte(`CREATE TABLE c (id INTEGER);`)},
+		"migrations/V1__a.sql":    {Data: []byte(`CREATE TABLE a (id INTEGER);`)},
+		"migrations/R__views.sql": {Data: []byte(`CREATE VIEW v AS SELECT 1;`)},
+		"migrations/setup.sql":    {Data: []byte(`not a migration`)},
+	}
+	pattern := regexp.MustCompile(`^V(?P<version>\d+)__(?P<name>.+)\.sql$`)
+
+	migrations, err := dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{FilenamePattern: pattern})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 4 {
+		t.Fatalf("expected 4 migrations, got %d", len(migrations))
+	}
+	for i, expected := range []struct {
+		version int64
+		name    string
+	}{{1, "a"}, {2, "b"}, {10, "c"}} {
+		if migrations[i].Version != expected.version || migrations[i].Name != expected.name {
+			t.Fatalf("expected version %d named %s at %d, got %+v", expected.version, expected.name, i, migrations[i])
+		}
+	}
+	if !migrations[3].Repeatable {
+		t.Fatal("expected R__views.sql to be repeatable")
+	}
+
+	_, err = dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{FilenamePattern: regexp.MustCompile(`^V(\d+)__(.+)$`)})
+	if err == nil || !strings.Contains(err.Error(), "named capture groups") {
+		t.Fatalf("expected the pattern to be rejected, got %v", err)
+	}
+}
+
+func TestLoadMigrationsRecursive(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/2024/0003__c.sql":      {Data: []byte(`CREATE TABLE c (id INTEGER);`)},
+		"migrations/2023/0002__b.sql":      {Data: []byte(`CREATE TABLE b (id INTEGER);`)},
+		"migrations/2023/0002__b.down.sql": {Data: []byte(`DROP TABLE b;`)},
+		"migrations/0001__a.sql":           {Data: []byte(`CREATE TABLE a (id INTEGER);`)},
+	}
+
+	migrations, err := dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, m := range migrations {
+		files = append(files, m.File)
+	}
+	if strings.Join(files, ",") != "0001__a.sql,2023/0002__b.sql,2024/0003__c.sql" {
+		t.Fatalf("unexpected files %v", files)
+	}
+	sum, err := dsync.HashFile(fsys, "migrations/"+migrations[1].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != migrations[1].Checksum {
+		t.Fatalf("expected the checksum of %s, got %d", migrations[1].File, migrations[1].Checksum)
+	}
+
+	// versions are unique across the directories
+	fsys["migrations/2024/0002__d.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE d (id INTEGER);`)}
+	if _, err := dsync.LoadMigrations(fsys, "migrations", dsync.LoadOptions{Recursive: true}); err == nil ||
+		!strings.Contains(err.Error(), "duplicate migration version 2") {
+		t.Fatalf("expected a duplicate version, got %v", err)
+	}
+}
+
+func TestNewMigrationFilename(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0000009__b.sql":      {Data: []byte(`SELECT 1;`)},
+		"migrations/0000011__c.sql.gz":   {Data: []byte(`SELECT 1;`)},
+		"migrations/0000011__c.down.sql": {Data: []byte(`SELECT 1;`)},
+		"migrations/R__views.sql":        {Data: []byte(`SELECT 1;`)},
+		"migrations/README.md":           {Data: []byte(`not a migration`)},
+		"empty/.keep":                    {Data: nil},
+	}
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := dsync.NewMigrationFilename(entries, "add_users"); err != nil || name != "0000012__add_users.sql" {
+		t.Fatalf("expected 0000012__add_users.sql, got %q, %v", name, err)
+	}
+	if name, err := dsync.NewMigrationFilename(entries, "add_users.sql"); err != nil || name != "0000012__add_users.sql" {
+		t.Fatalf("expected the extension to be kept, got %q, %v", name, err)
+	}
+	for _, invalid := range []string{"", "_users", "2024/users"} {
+		if _, err := dsync.NewMigrationFilename(entries, invalid); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+
+	empty, err := fs.ReadDir(fsys, "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := dsync.NewMigrationFilename(empty, "init"); err != nil || name != "0001__init.sql" {
+		t.Fatalf("expected 0001__init.sql, got %q, %v", name, err)
+	}
+	if name, err := (dsync.Migrator{BaseVersion: 100}).NewMigrationFilename(empty, "init"); err != nil || name != "0100__init.sql" {
+		t.Fatalf("expected 0100__init.sql, got %q, %v", name, err)
+	}
+
+	fsys["migrations/12_bad.sql"] = &fstest.MapFile{Data: []byte(`SELECT 1;`)}
+	if entries, err = fs.ReadDir(fsys, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dsync.NewMigrationFilename(entries, "add_users"); err == nil {
+		t.Fatal("expected the invalid file name to be reported")
+	}
+}
+
+func TestVerifyDirectory(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001__a.sql": {Data: []byte(`CREATE TABLE a (id INTEGER);`)},
+		"migrations/0002__b.sql": {Data: []byte(`CREATE TABLE b (id INTEGER);`)},
+		"migrations/README.md":   {Data: []byte(`not a migration`)},
+	}
+	if err := dsync.VerifyDirectory(fsys, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["migrations/0002__c.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE c (id INTEGER);`)}
+	fsys["migrations/third.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE d (id INTEGER);`)}
+	var loadErr dsync.LoadError
+	if err := dsync.VerifyDirectory(fsys, "migrations"); !errors.As(err, &loadErr) || len(loadErr.Errors) != 2 {
+		t.Fatalf("expected the duplicate version and the invalid name to be reported, got %v", err)
+	}
+}
+
+func TestLintFilenames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001__init.sql":           {Data: []byte(`SELECT 1;`)},
+		"migrations/0002__add_users.sql":      {Data: []byte(`SELECT 1;`)},
+		"migrations/0002__add_users.down.sql": {Data: []byte(`SELECT 1;`)},
+		"migrations/03__AddOrders.sql":        {Data: []byte(`SELECT 1;`)},
+		"migrations/0004__seed.txt":           {Data: []byte(`SELECT 1;`)},
+		"migrations/0005_typo.sql":            {Data: []byte(`SELECT 1;`)},
+		"migrations/R__views.sql":             {Data: []byte(`SELECT 1;`)},
+		"migrations/README.md":                {Data: []byte(`not a migration`)},
+	}
+	rules := dsync.LintRules{
+		VersionWidth: 4,
+		NamePattern:  regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`),
+		Extensions:   []string{".sql"},
+	}
+
+	var got []string
+	for _, issue := range dsync.LintFilenames(fsys, "migrations", rules) {
+		got = append(got, issue.File+" "+issue.Rule)
+	}
+	expected := []string{
+		"migrations/0004__seed.txt extension",
+		"migrations/0005_typo.sql grammar",
+		"migrations/03__AddOrders.sql version-width",
+		"migrations/03__AddOrders.sql name",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected issues:\n%s", strings.Join(got, "\n"))
+	}
+
+	issues := dsync.LintFilenames(fsys, "missing", rules)
+	if len(issues) != 1 || issues[0].Rule != dsync.LintRead {
+		t.Fatalf("expected the unreadable directory to be reported, got %v", issues)
+	}
+	if s := (dsync.LintIssue{File: "m/1__a.sql", Rule: dsync.LintVersionWidth, Message: "too short"}).String(); s != "m/1__a.sql: version-width: too short" {
+		t.Fatalf("unexpected format %q", s)
+	}
+}
+
+func TestHashFileChecksum(t *testing.T) {
+	// larger than any read buffer, the checksum must stay the CRC32 of the whole content
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 10000)
+	fsys := fstest.MapFS{"0001__a.sql": {Data: data}}
+
+	checksum, err := dsync.HashFile(fsys, "0001__a.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum != int64(crc32.ChecksumIEEE(data)) {
+		t.Fatalf("unexpected checksum %d", checksum)
+	}
+}
+
+func BenchmarkHashFile(b *testing.B) {
+	dir := b.TempDir()
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 100<<20/26)
+	if err := os.WriteFile(filepath.Join(dir, "0001__large.sql"), data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	fsys := os.DirFS(dir)
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dsync.HashFile(fsys, "0001__large.sql"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
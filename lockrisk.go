@@ -0,0 +1,100 @@
+package dsync
+
+import (
+	"regexp"
+	"strings"
+)
+
+// LockRisk How disruptive the locks taken by a migration are expected to be for concurrent traffic
+type LockRisk int
+
+const (
+	// LockRiskNone Only new objects are touched
+	LockRiskNone LockRisk = iota
+	// LockRiskLow Locks that neither block reads nor writes on existing tables
+	LockRiskLow
+	// LockRiskMedium Locks that block writes, or a short ACCESS EXCLUSIVE lock without a table rewrite
+	LockRiskMedium
+	// LockRiskHigh ACCESS EXCLUSIVE locks held for the duration of a table rewrite or scan, blocking reads and writes
+	LockRiskHigh
+)
+
+func (r LockRisk) String() string {
+	switch r {
+	case LockRiskNone:
+		return "none"
+	case LockRiskLow:
+		return "low"
+	case LockRiskMedium:
+		return "medium"
+	case LockRiskHigh:
+		return "high"
+	}
+	return "unknown"
+}
+
+type lockRule struct {
+	pattern *regexp.Regexp
+	risk    LockRisk
+	reason  string
+}
+
+// lockRules Evaluated in order, the first matching rule classifies a statement. The rules follow the
+// Postgres lock levels.
+var lockRules = []lockRule{
+	{regexp.MustCompile(`^CREATE\s+(UNIQUE\s+)?INDEX\s+CONCURRENTLY\b`), LockRiskLow, "concurrent index creation does not block writes"},
+	{regexp.MustCompile(`^CREATE\s+(UNIQUE\s+)?INDEX\b`), LockRiskMedium, "index creation without CONCURRENTLY blocks writes"},
+	{regexp.MustCompile(`^(DROP|REINDEX)\s+(INDEX\s+|TABLE\s+)?CONCURRENTLY\b`), LockRiskLow, "concurrent operation does not block writes"},
+	{regexp.MustCompile(`^DROP\s+INDEX\b`), LockRiskHigh, "dropping an index takes an ACCESS EXCLUSIVE lock on its table"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\bVALIDATE\s+CONSTRAINT\b`), LockRiskLow, "constraint validation does not block writes"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\bADD\s+(COLUMN\s+)?.*\bDEFAULT\b`), LockRiskHigh, "adding a column with a default rewrites the table before Postgres 11"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\b(TYPE|SET\s+DATA\s+TYPE)\b`), LockRiskHigh, "changing a column type rewrites the table"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\bSET\s+NOT\s+NULL\b`), LockRiskHigh, "SET NOT NULL scans the table under an ACCESS EXCLUSIVE lock"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\bADD\s+(CONSTRAINT\s+\S+\s+)?(PRIMARY\s+KEY|UNIQUE)\b`), LockRiskHigh, "adding a unique constraint builds an index under an ACCESS EXCLUSIVE lock"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\bNOT\s+VALID\b`), LockRiskMedium, "NOT VALID constraints skip the table scan"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b.*\bFOREIGN\s+KEY\b`), LockRiskHigh, "adding a validated foreign key scans the table and blocks writes on both tables"},
+	{regexp.MustCompile(`^ALTER\s+TABLE\b`), LockRiskMedium, "ALTER TABLE takes a short ACCESS EXCLUSIVE lock"},
+	{regexp.MustCompile(`^(DROP\s+TABLE|TRUNCATE|LOCK|CLUSTER|VACUUM\s+FULL|REINDEX)\b`), LockRiskHigh, "takes an ACCESS EXCLUSIVE lock"},
+	{regexp.MustCompile(`^(UPDATE|DELETE)\b.*\bWHERE\b`), LockRiskLow, "row level locks"},
+	{regexp.MustCompile(`^(UPDATE|DELETE)\b`), LockRiskMedium, "unqualified UPDATE/DELETE locks every row of the table"},
+	{regexp.MustCompile(`^(INSERT|COPY)\b`), LockRiskLow, "row level locks"},
+	{regexp.MustCompile(`^(CREATE|COMMENT|GRANT|REVOKE|SET|SELECT|DO|BEGIN|COMMIT)\b`), LockRiskNone, "no locks on existing tables"},
+}
+
+var lineComment = regexp.MustCompile(`--[^\n]*`)
+
+// AssessLockRisk Classify the statements of a migration script by lock severity. The returned risk is the
+// highest among all statements, along with the reasons for every statement rated above LockRiskNone.
+//
+// This is a static, heuristic analysis based on Postgres lock levels: statements are split on semicolons
+// and matched against a fixed rule set, so dollar quoted bodies, dynamic SQL and server version differences
+// are not taken into account. Unrecognized statements are rated LockRiskMedium.
+func AssessLockRisk(script string) (LockRisk, []string) {
+	var risk LockRisk
+	var reasons []string
+
+	script = lineComment.ReplaceAllString(script, "")
+	for _, statement := range SplitStatements(script) {
+		statement = strings.Join(strings.Fields(statement), " ")
+		if len(statement) == 0 {
+			continue
+		}
+		r, reason := assessStatement(strings.ToUpper(statement))
+		if r > LockRiskNone {
+			reasons = append(reasons, reason+": "+statement)
+		}
+		if r > risk {
+			risk = r
+		}
+	}
+	return risk, reasons
+}
+
+func assessStatement(statement string) (LockRisk, string) {
+	for _, rule := range lockRules {
+		if rule.pattern.MatchString(statement) {
+			return rule.risk, rule.reason
+		}
+	}
+	return LockRiskMedium, "unrecognized statement"
+}
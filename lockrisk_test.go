@@ -0,0 +1,37 @@
+package dsync_test
+
+import (
+	"testing"
+
+	"github.com/SharkFourSix/dsync"
+)
+
+func TestAssessLockRisk(t *testing.T) {
+	tests := []struct {
+		script string
+		risk   dsync.LockRisk
+	}{
+		{`CREATE TABLE users (id INT PRIMARY KEY, name TEXT);`, dsync.LockRiskNone},
+		{`CREATE INDEX CONCURRENTLY users_name ON users (name);`, dsync.LockRiskLow},
+		{`INSERT INTO users (id, name) VALUES (1, 'root');`, dsync.LockRiskLow},
+		{`ALTER TABLE users VALIDATE CONSTRAINT users_fk;`, dsync.LockRiskLow},
+		{`ALTER TABLE users ADD COLUMN email TEXT;`, dsync.LockRiskMedium},
+		{`CREATE UNIQUE INDEX users_name ON users (name);`, dsync.LockRiskMedium},
+		{`DELETE FROM users;`, dsync.LockRiskMedium},
+		{`ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT true;`, dsync.LockRiskHigh},
+		{`ALTER TABLE users ALTER COLUMN name TYPE VARCHAR(64);`, dsync.LockRiskHigh},
+		{`-- harmless comment; DROP TABLE users
+		CREATE TABLE roles (id INT);
+		TRUNCATE users;`, dsync.LockRiskHigh},
+	}
+
+	for _, test := range tests {
+		risk, reasons := dsync.AssessLockRisk(test.script)
+		if risk != test.risk {
+			t.Errorf("%q: expected %s, got %s (%v)", test.script, test.risk, risk, reasons)
+		}
+		if risk > dsync.LockRiskNone && len(reasons) == 0 {
+			t.Errorf("%q: expected reasons for risk %s", test.script, risk)
+		}
+	}
+}
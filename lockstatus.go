@@ -0,0 +1,80 @@
+package dsync
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockStatus Holder of the migration lock, see Migrator.LockStatus
+type LockStatus struct {
+	// Held The lock is currently held
+	Held bool
+	// Owner Holder of the lock as far as the database can tell: the owner recorded in the lock row (host/pid/nonce,
+	// see Migrator.UseLockTable), or the database session holding the session lock (e.g. "pid 4242")
+	Owner string
+	// LockedAt When the lock row was taken, zero for session locks
+	LockedAt time.Time
+}
+
+// LockBreaker is implemented by Locker data sources that can report and break the session lock taken by Lock,
+// for operators to recover from a migrator whose database session outlived it
+type LockBreaker interface {
+	// LockStatus Report whether the lock taken by Lock is held, and by which database session
+	LockStatus(ctx context.Context) (LockStatus, error)
+
+	// ForceUnlock Release the lock taken by Lock whoever holds it, by terminating the database session holding it
+	ForceUnlock(ctx context.Context) error
+}
+
+// TableLockBreaker is implemented by TableLocker data sources that can report and break the lock row
+type TableLockBreaker interface {
+	// LockTableStatus Report whether the lock row is held, and by whom
+	LockTableStatus(ctx context.Context) (LockStatus, error)
+
+	// ForceUnlockTable Delete the lock row whoever holds it
+	ForceUnlockTable(ctx context.Context) error
+}
+
+// LockStatus Report whether the migration lock is held, and by whom: the lock row when UseLockTable is set, the
+// session lock of the data source otherwise
+func (migrator Migrator) LockStatus(ds DataSource) (LockStatus, error) {
+	if migrator.UseLockTable {
+		breaker, ok := ds.(TableLockBreaker)
+		if !ok {
+			return LockStatus{}, errors.New("data source cannot report its lock row")
+		}
+		return breaker.LockTableStatus(context.Background())
+	}
+	breaker, ok := ds.(LockBreaker)
+	if !ok {
+		return LockStatus{}, errors.New("data source cannot report its lock")
+	}
+	return breaker.LockStatus(context.Background())
+}
+
+// ForceUnlock Release the migration lock whoever holds it: the lock row when UseLockTable is set, the session lock
+// of the data source otherwise, whose database session is terminated. Only meant for operators recovering from a
+// migrator that crashed or hangs while holding the lock, never call it while a run may be in progress. A warning is
+// logged to Logger.
+func (migrator Migrator) ForceUnlock(ds DataSource) error {
+	status, err := migrator.LockStatus(ds)
+	if err != nil {
+		return err
+	}
+	if !status.Held {
+		return nil
+	}
+
+	if migrator.UseLockTable {
+		err = ds.(TableLockBreaker).ForceUnlockTable(context.Background())
+	} else {
+		err = ds.(LockBreaker).ForceUnlock(context.Background())
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to force unlock")
+	}
+	migrator.logf("dsync: WARNING: forcibly released the migration lock held by %s", status.Owner)
+	return nil
+}
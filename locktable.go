@@ -0,0 +1,159 @@
+package dsync
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lock_table_poll_interval Wait between attempts to take a lock row held by another instance
+const lock_table_poll_interval = 200 * time.Millisecond
+
+// TableLocker is implemented by data sources that can serialize migration runs with a row of a dedicated lock table,
+// named after the migration table with a _lock suffix (see Migrator.UseLockTable). Unlike Locker, it only relies on
+// plain SQL and a primary key.
+type TableLocker interface {
+	// TryLockTable Insert the lock row on behalf of owner, recording now as the time it was taken, after creating
+	// the lock table if needed and deleting the row when it was taken more than staleAfter (when not zero) before
+	// now. Returns false, without an error, when the row is held.
+	TryLockTable(ctx context.Context, owner string, now time.Time, staleAfter time.Duration) (bool, error)
+
+	// UnlockTable Delete the lock row if it is held by owner
+	UnlockTable(owner string) error
+}
+
+// lockOwner Identify the holder of a lock row, unique to each run
+func lockOwner() string {
+	var nonce [8]byte
+
+	host, _ := os.Hostname()
+	rand.Read(nonce[:])
+	return host + "/" + strconv.Itoa(os.Getpid()) + "/" + hex.EncodeToString(nonce[:])
+}
+
+// lockTable Wait for the lock row of ds until it is taken or ctx is done, returning the function releasing it
+func (migrator Migrator) lockTable(ctx context.Context, ds DataSource) (func(), error) {
+	locker, ok := ds.(TableLocker)
+	if !ok {
+		return nil, errors.New("data source does not support lock tables")
+	}
+
+	owner := lockOwner()
+	for {
+		locked, err := locker.TryLockTable(ctx, owner, migrator.now(), migrator.LockTableStaleAfter)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to acquire migration lock row")
+		}
+		if locked {
+			return func() { locker.UnlockTable(owner) }, nil
+		}
+
+		timer := time.NewTimer(lock_table_poll_interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Wrap(ctx.Err(), "failed to acquire migration lock row")
+		case <-timer.C:
+		}
+	}
+}
+
+// lockTableName Unquoted name of the lock table of the migration table
+func (b SQLSource) lockTableName() string {
+	return b.tablename + "_lock"
+}
+
+// hasLockTable Reports whether the lock table exists, with the TableExistsQuery of the dialect
+func (b SQLSource) hasLockTable(ctx context.Context) (bool, error) {
+	query, args := b.dialect.TableExistsQuery(b.lockTableName())
+	var exists bool
+	if err := b.DB.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// createLockTable Create the lock table if it does not exist. A table created concurrently by another instance is
+// not an error.
+func (b SQLSource) createLockTable(ctx context.Context) error {
+	exists, err := b.hasLockTable(ctx)
+	if err != nil || exists {
+		return err
+	}
+	create := "CREATE TABLE " + b.QuoteTable(b.lockTableName()) + " (Id INTEGER NOT NULL PRIMARY KEY, Owner VARCHAR(255) NOT NULL, LockedAt BIGINT NOT NULL)"
+	if _, err := b.DB.ExecContext(ctx, create); err != nil {
+		if exists, _ := b.hasLockTable(ctx); exists {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// TryLockTable Insert the lock row of the lock table, see TableLocker
+func (b SQLSource) TryLockTable(ctx context.Context, owner string, now time.Time, staleAfter time.Duration) (bool, error) {
+	if err := b.createLockTable(ctx); err != nil {
+		return false, err
+	}
+	table, p := b.QuoteTable(b.lockTableName()), b.dialect.Placeholder
+	if staleAfter > 0 {
+		stale := "DELETE FROM " + table + " WHERE Id = 1 AND LockedAt < " + p(1)
+		if _, err := b.DB.ExecContext(ctx, stale, now.Add(-staleAfter).UnixMilli()); err != nil {
+			return false, err
+		}
+	}
+	lock := "INSERT INTO " + table + " (Id, Owner, LockedAt) VALUES (1, " + p(1) + ", " + p(2) + ")"
+	if _, err := b.DB.ExecContext(ctx, lock, owner, now.UnixMilli()); err != nil {
+		// a primary key violation when the row is held
+		var held int
+		if qerr := b.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&held); qerr != nil || held == 0 {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// UnlockTable Delete the lock row held by owner, see TableLocker
+func (b SQLSource) UnlockTable(owner string) error {
+	_, err := b.DB.Exec("DELETE FROM "+b.QuoteTable(b.lockTableName())+" WHERE Id = 1 AND Owner = "+b.dialect.Placeholder(1), owner)
+	return err
+}
+
+// LockTableStatus Report the owner of the lock row, see TableLockBreaker. The lock is not held when the lock table
+// does not exist yet.
+func (b SQLSource) LockTableStatus(ctx context.Context) (LockStatus, error) {
+	var status LockStatus
+	var lockedAt int64
+
+	exists, err := b.hasLockTable(ctx)
+	if err != nil || !exists {
+		return status, err
+	}
+	err = b.DB.QueryRowContext(ctx, "SELECT Owner, LockedAt FROM "+b.QuoteTable(b.lockTableName())+" WHERE Id = 1").Scan(&status.Owner, &lockedAt)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	status.Held = true
+	status.LockedAt = time.UnixMilli(lockedAt).UTC()
+	return status, nil
+}
+
+// ForceUnlockTable Delete the lock row whoever holds it, see TableLockBreaker
+func (b SQLSource) ForceUnlockTable(ctx context.Context) error {
+	exists, err := b.hasLockTable(ctx)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = b.DB.ExecContext(ctx, "DELETE FROM "+b.QuoteTable(b.lockTableName())+" WHERE Id = 1")
+	return err
+}
@@ -0,0 +1,61 @@
+// Package otel Forwards the spans of dsync migration runs to OpenTelemetry. It is a separate module so that dsync
+// itself does not depend on OpenTelemetry.
+//
+//	migrator := dsync.Migrator{Tracer: otel.NewTracer(otel.DefaultTracer())}
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SharkFourSix/dsync"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName Name of the tracer returned by DefaultTracer
+const InstrumentationName = "github.com/SharkFourSix/dsync"
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// DefaultTracer Returns the dsync tracer of the global tracer provider
+func DefaultTracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// NewTracer Create a dsync.Tracer starting its spans with t. Failed spans record the error and an error status.
+func NewTracer(t trace.Tracer) dsync.Tracer {
+	return tracer{tracer: t}
+}
+
+func (t tracer) StartSpan(ctx context.Context, name string, attributes []dsync.Attribute) (context.Context, func(err error)) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(convert(attributes)...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func convert(attributes []dsync.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(a.Key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(a.Key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(a.Key, v))
+		default:
+			kvs = append(kvs, attribute.String(a.Key, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,61 @@
+package dsync
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseMigrationPattern Parse migration information from a file name using a pattern with the named capture groups
+// version and name, e.g. `^V(?P<version>\d+)__(?P<name>.+)$`. The pattern is matched against the file name without
+// the .gz suffix of compressed files. Files named R__<name>.sql remain repeatable migrations. Returns nil, without
+// an error, when the file name does not match.
+func ParseMigrationPattern(pattern *regexp.Regexp, filename string) (*Migration, error) {
+	return parseMigrationPattern(pattern, filename, false)
+}
+
+// parseMigrationPattern ParseMigrationPattern, parsing the version as a SemanticVersion when semantic is set
+func parseMigrationPattern(pattern *regexp.Regexp, filename string, semantic bool) (*Migration, error) {
+	if err := checkFilename(filename); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(filename, repeatable_prefix) {
+		return ParseMigration(filename)
+	}
+
+	match := pattern.FindStringSubmatch(trimCompressed(filename))
+	if match == nil {
+		return nil, nil
+	}
+
+	var version string
+	var migration = Migration{File: filename}
+	for i, group := range pattern.SubexpNames() {
+		switch group {
+		case "version":
+			version = match[i]
+		case "name":
+			migration.Name = match[i]
+		}
+	}
+
+	v, label, err := parseVersion(version, semantic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: error parsing migration file name", filename)
+	}
+	if migration.Name == "" {
+		return nil, parser_error{filename: filename, reason: "missing migration name"}
+	}
+	migration.Version = v
+	migration.VersionLabel = label
+	return &migration, nil
+}
+
+// validateFilenamePattern Check that a file name pattern captures the version and the name
+func validateFilenamePattern(pattern *regexp.Regexp) error {
+	if pattern.SubexpIndex("version") < 0 || pattern.SubexpIndex("name") < 0 {
+		return errors.Errorf("file name pattern %s must have the named capture groups version and name", pattern)
+	}
+	return nil
+}
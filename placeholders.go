@@ -0,0 +1,45 @@
+package dsync
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExpandPlaceholders Replace the ${name} tokens of a migration script with their value. "$${" stands for a literal
+// "${". Referencing an undefined placeholder is an error. Scripts are returned unchanged when there are no
+// placeholders, so that migrations written before placeholders were configured keep working.
+func ExpandPlaceholders(script string, placeholders map[string]string) (string, error) {
+	var builder strings.Builder
+
+	if len(placeholders) == 0 {
+		return script, nil
+	}
+
+	for {
+		i := strings.Index(script, "${")
+		if i < 0 {
+			builder.WriteString(script)
+			return builder.String(), nil
+		}
+		if i > 0 && script[i-1] == '$' {
+			// escaped, keep a single "${"
+			builder.WriteString(script[:i-1])
+			builder.WriteString("${")
+			script = script[i+2:]
+			continue
+		}
+		end := strings.IndexByte(script[i:], '}')
+		if end < 0 {
+			return "", errors.Errorf("unterminated placeholder %q", script[i:])
+		}
+		name := script[i+2 : i+end]
+		value, ok := placeholders[name]
+		if !ok {
+			return "", errors.Errorf("undefined placeholder ${%s}", name)
+		}
+		builder.WriteString(script[:i])
+		builder.WriteString(value)
+		script = script[i+end+1:]
+	}
+}
@@ -0,0 +1,95 @@
+package dsync
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumRepairer is implemented by data sources that can update the checksum recorded for a migration
+type ChecksumRepairer interface {
+	// UpdateChecksumContext Store the Checksum, Digest, Algorithm and Size of the migration in the row identified by
+	// its Id
+	UpdateChecksumContext(ctx context.Context, m *Migration) error
+}
+
+// Repair Re-hash the files of the applied migrations using the migrator's checksum options (NormalizeLineEndings,
+// ChecksumMode, ChecksumAlgorithm) and update the rows whose checksum or digest differs, in a single transaction. Any change to
+// an applied file is accepted, so only repair once the files have been reviewed. Rows whose file no longer exists
+// are left alone. Every updated row is logged to Migrator.Logger once committed. Returns the repaired migrations.
+func (migrator Migrator) Repair(ds DataSource) ([]Migration, error) {
+	var repaired, previous []Migration
+
+	repairer, ok := ds.(ChecksumRepairer)
+	if !ok {
+		return nil, errors.New("data source does not support checksum repairs")
+	}
+
+	unlock, err := migrator.lock(context.Background(), ds)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	info, err := ds.GetMigrationInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadDataSourceMigrations(cfs, ds, migrator.loadOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*Migration, len(migrations))
+	for i := range migrations {
+		files[strings.ToLower(trimCompressed(migrations[i].File))] = &migrations[i]
+	}
+
+	sortMigrations(info.Migrations)
+	for _, dbm := range info.Migrations {
+		file, ok := files[strings.ToLower(trimCompressed(dbm.File))]
+		if !ok || (file.Checksum == dbm.Checksum && file.Digest == dbm.Digest && file.Algorithm == dbm.Algorithm) {
+			continue
+		}
+		previous = append(previous, dbm)
+		dbm.Checksum = file.Checksum
+		dbm.Digest = file.Digest
+		dbm.Algorithm = file.Algorithm
+		dbm.Size = file.Size
+		repaired = append(repaired, dbm)
+	}
+	if len(repaired) == 0 {
+		return nil, nil
+	}
+
+	tx := transaction{ds: ds}
+	defer tx.rollback()
+
+	if err := tx.begin(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "repair failed.")
+	}
+	for i := range repaired {
+		if err := repairer.UpdateChecksumContext(context.Background(), &repaired[i]); err != nil {
+			return nil, errors.Wrap(err, "repair failed")
+		}
+	}
+	if err := tx.commit(); err != nil {
+		return nil, errors.Wrap(err, "repair failed")
+	}
+
+	for i, m := range repaired {
+		if m.Digest != "" && previous[i].Digest != m.Digest {
+			migrator.logf("dsync: repaired %s (version %d): digest %s -> %s", m.File, m.Version, previous[i].Digest, m.Digest)
+		} else {
+			migrator.logf("dsync: repaired %s (version %d): checksum %d -> %d", m.File, m.Version, previous[i].Checksum, m.Checksum)
+		}
+	}
+	return repaired, nil
+}
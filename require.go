@@ -0,0 +1,135 @@
+package dsync
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRequirementNotMet The server does not meet the requirement of a migration and Migrator.StrictRequirements is set
+var ErrRequirementNotMet = errors.New("migration requirement not met")
+
+// ServerVersioner is implemented by data sources reporting the version of the database server, which migrations
+// declaring "-- dsync:require server>=<version>" are checked against
+type ServerVersioner interface {
+	// ServerVersionContext Returns the version as reported by the server, e.g. "14.5 (Debian 14.5-1)" or
+	// "10.6.12-MariaDB"
+	ServerVersionContext(ctx context.Context) (string, error)
+}
+
+// ServerRequirement Server version condition declared with "-- dsync:require server<op><version>", e.g.
+// "server>=14" or "server<8.0.13". Op is one of >=, >, <=, <, = and !=.
+type ServerRequirement struct {
+	Op      string
+	Version string
+}
+
+func (r ServerRequirement) String() string {
+	return "server" + r.Op + r.Version
+}
+
+// requirement_pattern server<op><version>, spaces allowed around the operator
+var requirement_pattern = regexp.MustCompile(`^server\s*(>=|<=|!=|==|=|>|<)\s*(\d+(?:\.\d+)*)$`)
+
+// server_version_pattern Leading dotted version of a server version string
+var server_version_pattern = regexp.MustCompile(`\d+(?:\.\d+)*`)
+
+// ParseServerRequirement Parse the value of a "-- dsync:require" directive
+func ParseServerRequirement(value string) (ServerRequirement, error) {
+	match := requirement_pattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil {
+		return ServerRequirement{}, errors.Errorf("invalid requirement %q: expected server<op><version>, e.g. server>=14", value)
+	}
+	op := match[1]
+	if op == "==" {
+		op = "="
+	}
+	return ServerRequirement{Op: op, Version: match[2]}, nil
+}
+
+// SatisfiedBy Compare the version reported by the server with the required one, numerically component by component
+// ("14" equals "14.0"). Anything after the leading dotted version (build, distribution) is ignored.
+func (r ServerRequirement) SatisfiedBy(serverVersion string) (bool, error) {
+	version := server_version_pattern.FindString(serverVersion)
+	if version == "" {
+		return false, errors.Errorf("unrecognized server version %q", serverVersion)
+	}
+	c := compareVersions(version, r.Version)
+	switch r.Op {
+	case ">=":
+		return c >= 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "<":
+		return c < 0, nil
+	case "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	}
+	return false, errors.Errorf("invalid requirement operator %q", r.Op)
+}
+
+// compareVersions Compare two dotted versions, missing components count as 0
+func compareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int64
+		if i < len(as) {
+			x, _ = strconv.ParseInt(as[i], 10, 64)
+		}
+		if i < len(bs) {
+			y, _ = strconv.ParseInt(bs[i], 10, 64)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// RequirementError The server does not meet the requirement of a migration, returned instead of recording it as a
+// no-op when Migrator.StrictRequirements is set. Matches ErrRequirementNotMet.
+type RequirementError struct {
+	File          string
+	Requirement   ServerRequirement
+	ServerVersion string
+}
+
+func (e RequirementError) Error() string {
+	return e.File + ": requires " + e.Requirement.String() + ", server version is " + e.ServerVersion
+}
+
+func (e RequirementError) Unwrap() error {
+	return ErrRequirementNotMet
+}
+
+// serverVersion Query the version of the server of ds
+func serverVersion(ctx context.Context, ds DataSource, m *Migration) (string, error) {
+	versioner, ok := ds.(ServerVersioner)
+	if !ok {
+		return "", &MigrationError{Err: errors.New("data source does not report its server version"), Migration: m}
+	}
+	version, err := versioner.ServerVersionContext(ctx)
+	if err != nil {
+		return "", &MigrationError{Err: errors.Wrap(err, "failed to query the server version"), Migration: m}
+	}
+	return version, nil
+}
+
+// recordNoOp Record a migration whose requirement is not met as applied, without executing it
+func recordNoOp(ctx context.Context, ds DataSource, m *Migration) error {
+	recorder, ok := ds.(MigrationRecorder)
+	if !ok {
+		return &MigrationError{Err: errors.New("data source cannot record migrations without applying them"), Migration: m}
+	}
+	return recorder.RecordMigrationContext(ctx, m)
+}
@@ -0,0 +1 @@
+IF OBJECT_ID('user_test', 'U') IS NULL CREATE TABLE user_test (id INT IDENTITY(1,1) NOT NULL, _name NVARCHAR(MAX) NOT NULL);
\ No newline at end of file
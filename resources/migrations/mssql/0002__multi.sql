@@ -0,0 +1,3 @@
+IF OBJECT_ID('animals', 'U') IS NULL CREATE TABLE animals(id INT PRIMARY KEY, someCode INT, animalName VARCHAR(100) NOT NULL);
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = 'animalCode') CREATE INDEX animalCode ON animals( someCode );
\ No newline at end of file
@@ -0,0 +1,88 @@
+package dsync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy Retries a whole migration run that failed on a transient error, e.g. a connection dropped during a
+// database failover. Migrations committed before the failure are verified and skipped by the next attempt like on
+// any other run.
+type RetryPolicy struct {
+	// MaxAttempts Number of attempts including the first one. Retries are disabled below 2.
+	MaxAttempts int
+	// Backoff Wait before the first retry, doubled before each following one
+	Backoff time.Duration
+	// IsTransient Classifies errors instead of the data source when set
+	IsTransient func(err error) bool
+}
+
+// TransientErrorClassifier is implemented by data sources that can tell the transient errors of their driver
+// (dropped connections, server shutting down, ...) worth retrying a run on (see Migrator.RetryPolicy)
+type TransientErrorClassifier interface {
+	IsTransient(err error) bool
+}
+
+// IsTransientError Reports whether err is a connection error that is not specific to any driver: a bad or closed
+// connection, an unexpected end of stream, a network error or a reset or refused connection. Cancellations are never
+// transient.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
+
+// transient Reports whether a run that failed with err should be retried
+func (migrator Migrator) transient(ds DataSource, err error) bool {
+	// verification failures are final whatever the classifier says
+	if errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrConflict) || errors.Is(err, ErrOutOfOrder) {
+		return false
+	}
+	if migrator.RetryPolicy.IsTransient != nil {
+		return migrator.RetryPolicy.IsTransient(err)
+	}
+	if classifier, ok := ds.(TransientErrorClassifier); ok {
+		return classifier.IsTransient(err)
+	}
+	return IsTransientError(err)
+}
+
+// retry Call run until it succeeds, fails on an error that is not transient or the attempts of the retry policy
+// are exhausted, returning the migrations committed by every attempt
+func (migrator Migrator) retry(ctx context.Context, ds DataSource, run func() ([]*Migration, error)) ([]*Migration, error) {
+	var applied []*Migration
+
+	backoff := migrator.RetryPolicy.Backoff
+	for attempt := 1; ; attempt++ {
+		committed, err := run()
+		applied = append(applied, committed...)
+		if err == nil || attempt >= migrator.RetryPolicy.MaxAttempts || ctx.Err() != nil || !migrator.transient(ds, err) {
+			return applied, err
+		}
+
+		migrator.logf("dsync: attempt %d of %d failed, retrying in %s: %v", attempt, migrator.RetryPolicy.MaxAttempts, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return applied, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
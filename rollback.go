@@ -0,0 +1,181 @@
+package dsync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Reverter is implemented by data sources supporting rollbacks
+type Reverter interface {
+	// RevertMigrationContext Executes the down script of an applied migration and deletes the migration from the
+	// migration table, within the active transaction
+	RevertMigrationContext(ctx context.Context, migration *Migration, script string) error
+}
+
+// DownFile Returns the name of the down script paired with a migration file, e.g. 0001__init.down.sql. The down
+// script of a compressed file is compressed as well (0001__init.down.sql.gz). With Config.Extensions, .down is
+// inserted before the extension of the file (0001__init.down.sql.tmpl).
+func DownFile(file string) string {
+	return downFile(file, nil)
+}
+
+// Rollback Revert the most recent steps migrations, in reverse version order, by executing their down scripts: the
+// down section of the migration file when it is split with "-- +dsync Up" and "-- +dsync Down" lines (see
+// DownSection), the paired file otherwise (see DownFile). Nothing is reverted unless every one of them has a down
+// script. The down scripts and the
+// removal of the corresponding migration rows are committed in a single transaction.
+func (migrator Migrator) Rollback(ds DataSource, steps int) error {
+	if steps <= 0 {
+		return errors.New("rollback steps must be positive")
+	}
+
+	reverter, ok := ds.(Reverter)
+	if !ok {
+		return errors.New("data source does not support rollbacks")
+	}
+
+	unlock, err := migrator.lock(context.Background(), ds)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := ds.GetMigrationInfo()
+	if err != nil {
+		return err
+	}
+	if steps > len(info.Migrations) {
+		return errors.Errorf("cannot roll back %d migrations, only %d applied", steps, len(info.Migrations))
+	}
+
+	sortMigrations(info.Migrations)
+
+	return migrator.revert(ds, reverter, info.Migrations[len(info.Migrations)-steps:])
+}
+
+// MigrateTo Bring the database to the target version: pending migrations up to target are applied as with
+// Migrator.TargetVersion when target is ahead of the current version, applied migrations after target are rolled back
+// as with Rollback when it is behind. Nothing is rolled back unless every one of them has a down script. A target of 0
+// rolls back every versioned migration; MigrateTo does nothing when the database is already at target.
+func (migrator Migrator) MigrateTo(ds DataSource, target int64) error {
+	if target < 0 {
+		return errors.Errorf("invalid target version %d", target)
+	}
+
+	info, err := ds.GetMigrationInfo()
+	if err != nil {
+		return err
+	}
+	current := latestVersion(info.Migrations)
+	if target == current {
+		return nil
+	}
+	if target > current {
+		migrator.TargetVersion = target
+		return migrator.Migrate(ds)
+	}
+
+	reverter, ok := ds.(Reverter)
+	if !ok {
+		return errors.New("data source does not support rollbacks")
+	}
+
+	unlock, err := migrator.lock(context.Background(), ds)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the migrations may have changed while waiting for the lock
+	info, err = ds.GetMigrationInfo()
+	if err != nil {
+		return err
+	}
+	if target != 0 && !hasVersion(info.Migrations, target) {
+		return errors.Errorf("target version %d does not correspond to any applied migration", target)
+	}
+
+	sortMigrations(info.Migrations)
+
+	var reverted []Migration
+	for _, m := range info.Migrations {
+		if m.Version > target {
+			reverted = append(reverted, m)
+		}
+	}
+	if len(reverted) == 0 {
+		return nil
+	}
+	return migrator.revert(ds, reverter, reverted)
+}
+
+// revert Revert the given migrations, sorted by version, latest first. Nothing is reverted unless every one of them
+// has a down script, except no-op migrations whose script never ran: only their record is deleted.
+func (migrator Migrator) revert(ds DataSource, reverter Reverter, reverted []Migration) error {
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return err
+	}
+
+	var scripts []string
+	for i := len(reverted) - 1; i >= 0; i-- {
+		if reverted[i].NoOp {
+			scripts = append(scripts, "")
+			continue
+		}
+		file, script, err := downScript(cfs, ds, &reverted[i])
+		if err != nil {
+			return &MigrationError{Err: errors.Wrap(err, "missing down script, nothing was rolled back"), Migration: &reverted[i]}
+		}
+		rendered, err := RenderTemplate(file, bytes.NewReader(script), migrator.TemplateData)
+		if err == nil {
+			script, err = io.ReadAll(rendered)
+		}
+		if err != nil {
+			return &MigrationError{Err: err, Migration: &reverted[i]}
+		}
+		scripts = append(scripts, string(script))
+	}
+
+	tx := transaction{ds: ds}
+	defer tx.rollback()
+
+	if err := tx.begin(context.Background()); err != nil {
+		return errors.Wrap(err, "rollback failed.")
+	}
+	for i, script := range scripts {
+		m := &reverted[len(reverted)-1-i]
+		m.Placeholders = migrator.Placeholders
+		if err := reverter.RevertMigrationContext(context.Background(), m, script); err != nil {
+			return errors.Wrap(err, "rollback failed")
+		}
+	}
+	if err := tx.commit(); err != nil {
+		return errors.Wrap(err, "rollback failed")
+	}
+
+	return nil
+}
+
+// downScript Returns the name and content of the down script of an applied migration, the down section of its file
+// when it has one
+func downScript(cfs fs.FS, ds DataSource, m *Migration) (string, []byte, error) {
+	if script, err := ReadMigrationFile(cfs, filepath.Join(ds.GetPath(), m.File)); err == nil {
+		down, ok, err := DownSection(bytes.NewReader(script))
+		if err != nil {
+			return "", nil, err
+		}
+		if ok {
+			return m.File, []byte(down), nil
+		}
+	}
+
+	file := downFile(m.File, dataSourceExtensions(ds))
+	script, err := ReadMigrationFile(cfs, filepath.Join(ds.GetPath(), file))
+	return file, script, err
+}
@@ -0,0 +1,101 @@
+package dsync
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+type section int
+
+const (
+	section_none section = iota
+	section_up
+	section_down
+)
+
+// sectionMarker Returns the section started by a "-- +dsync Up" or "-- +dsync Down" line, matched ignoring case
+// and surrounding spaces, section_none for any other line
+func sectionMarker(line string) section {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "--") {
+		return section_none
+	}
+	fields := strings.Fields(strings.ToLower(strings.TrimPrefix(line, "--")))
+	if len(fields) != 2 || fields[0] != "+dsync" {
+		return section_none
+	}
+	switch fields[1] {
+	case "up":
+		return section_up
+	case "down":
+		return section_down
+	}
+	return section_none
+}
+
+// upSectionReader Streams the lines of a script outside of its down sections, without the section markers
+type upSectionReader struct {
+	r    *bufio.Reader
+	down bool
+	buf  string
+	err  error
+}
+
+func (u *upSectionReader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		var line string
+		line, u.err = u.r.ReadString('\n')
+		switch sectionMarker(line) {
+		case section_up:
+			u.down = false
+		case section_down:
+			u.down = true
+		default:
+			if !u.down {
+				u.buf = line
+			}
+		}
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+// UpSection Returns the script applied by a migration file: the whole file, unless it is split into sections with
+// "-- +dsync Up" and "-- +dsync Down" lines, in which case the down sections and the markers are left out. Lines
+// before the first marker, such as directives, belong to the up section. The file is streamed.
+func UpSection(script io.Reader) io.Reader {
+	return &upSectionReader{r: bufio.NewReader(script)}
+}
+
+// DownSection Returns the down sections of a migration file split with "-- +dsync Up" and "-- +dsync Down" lines,
+// false when it has none
+func DownSection(script io.Reader) (string, bool, error) {
+	var builder strings.Builder
+	var found, down bool
+
+	r := bufio.NewReader(script)
+	for {
+		line, err := r.ReadString('\n')
+		switch sectionMarker(line) {
+		case section_up:
+			down = false
+		case section_down:
+			down, found = true, true
+		default:
+			if down {
+				builder.WriteString(line)
+			}
+		}
+		if err == io.EOF {
+			return builder.String(), found, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+	}
+}
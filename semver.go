@@ -0,0 +1,50 @@
+package dsync
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// semver_component_limit Exclusive upper bound of each component of a semantic version
+const semver_component_limit = 1000000
+
+// SemanticVersion Encode a dotted version of up to three numeric components (1, 1.2 or 1.2.10) into an integer
+// ordered component-wise: 1.2.10 is above 1.2.9 and 1.10 is above 1.9. Missing components count as 0, so 1.2
+// equals 1.2.0. Each component must be below 1000000. Use it to compute Migrator.FromVersion, ToVersion and
+// TargetVersion when SemanticVersions is set.
+func SemanticVersion(version string) (int64, error) {
+	components := strings.Split(version, ".")
+	if len(components) > 3 {
+		return 0, errors.Errorf("invalid semantic version %q: at most 3 components are supported", version)
+	}
+
+	var encoded int64
+	for i := 0; i < 3; i++ {
+		var component int64
+		if i < len(components) {
+			var err error
+			component, err = strconv.ParseInt(components[i], 10, 64)
+			if err != nil || component < 0 {
+				return 0, errors.Errorf("invalid semantic version %q", version)
+			}
+			if component >= semver_component_limit {
+				return 0, errors.Errorf("invalid semantic version %q: components must be below %d", version, semver_component_limit)
+			}
+		}
+		encoded = encoded*semver_component_limit + component
+	}
+	return encoded, nil
+}
+
+// parseVersion Parse the version part of a migration file name. Integer versions are labelled with versionLabel,
+// semantic versions as written.
+func parseVersion(version string, semantic bool) (int64, string, error) {
+	if semantic {
+		v, err := SemanticVersion(version)
+		return v, version, err
+	}
+	v, err := strconv.ParseInt(version, 10, 64)
+	return v, versionLabel(version), err
+}
@@ -0,0 +1,37 @@
+package dsync
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// SessionExecer Common interface of *sql.Conn and *sql.Tx session setup statements run on
+type SessionExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// RunSessionSetup Execute the statements of Config.SessionSetup, in order, on a transaction or a dedicated connection
+func RunSessionSetup(ctx context.Context, conn SessionExecer, statements []string) error {
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return errors.Wrapf(err, "session setup statement %q failed", statement)
+		}
+	}
+	return nil
+}
+
+// OpenSession Returns a connection of db on which the statements of Config.SessionSetup have run, to be closed by the
+// caller
+func OpenSession(ctx context.Context, db *sql.DB, statements []string) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := RunSessionSetup(ctx, conn, statements); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
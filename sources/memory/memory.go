@@ -0,0 +1,349 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SharkFourSix/dsync"
+)
+
+// DataSource A data source recording migrations in memory instead of a database, for tests. Migration scripts are
+// read, rendered and split into statements, but not executed. Transactions are honored: migrations applied
+// within a transaction are discarded unless it is committed.
+type DataSource struct {
+	mu         sync.Mutex
+	basepath   string
+	basepaths  []string
+	extensions []string
+	setFS      fs.FS
+	tablename  string
+	// created GetMigrationInfo has been called, which creates the migration table of the other data sources
+	created bool
+	// committed Migrations recorded outside of any transaction or by committed transactions
+	committed []dsync.Migration
+	// pending Copy of committed modified by the active transaction, nil outside of a transaction
+	pending    []dsync.Migration
+	inTx       bool
+	successful bool
+	nextId     uint32
+	failures   map[string]error
+	statements []string
+	// serverVersion Reported by ServerVersion, see SetServerVersion
+	serverVersion string
+}
+
+// New Create an empty in memory data source over the change set of cfg
+func New(cfg *dsync.Config) (*DataSource, error) {
+	if err := dsync.ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &DataSource{
+		basepath:   cfg.BasepathOrDefault(),
+		basepaths:  cfg.Basepaths,
+		extensions: cfg.Extensions,
+		setFS:      cfg.FileSystem,
+		tablename:  cfg.TableNameOrDefault(),
+		failures:   map[string]error{},
+	}, nil
+}
+
+// Fail Make the application of the migration file fail with err, until cleared with a nil err
+func (p *DataSource) Fail(file string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		delete(p.failures, file)
+		return
+	}
+	p.failures[file] = err
+}
+
+// SetServerVersion Set the version ServerVersion reports, e.g. to cover "-- dsync:require" directives
+func (p *DataSource) SetServerVersion(version string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.serverVersion = version
+}
+
+// ServerVersion Returns the version set with SetServerVersion
+func (p *DataSource) ServerVersion() (string, error) {
+	return p.ServerVersionContext(context.Background())
+}
+
+// ServerVersionContext ServerVersion, see dsync.ServerVersioner
+func (p *DataSource) ServerVersionContext(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.serverVersion == "" {
+		return "", errors.New("no server version set")
+	}
+	return p.serverVersion, nil
+}
+
+// Migrations Returns the committed migration records, in the order they were recorded
+func (p *DataSource) Migrations() []dsync.Migration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]dsync.Migration(nil), p.committed...)
+}
+
+// Statements Returns the statements of every applied or reverted script, including the ones of discarded
+// transactions, with their placeholders expanded
+func (p *DataSource) Statements() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.statements...)
+}
+
+// records Returns the records of the active transaction, or the committed ones outside of a transaction
+func (p *DataSource) records() *[]dsync.Migration {
+	if p.inTx {
+		return &p.pending
+	}
+	return &p.committed
+}
+
+func (p *DataSource) BeginTransaction() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.inTx {
+		return errors.New("already in transaction")
+	}
+	p.inTx = true
+	p.pending = append([]dsync.Migration(nil), p.committed...)
+	return nil
+}
+
+func (p *DataSource) SetTransactionSuccessful(b bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.successful = b
+}
+
+func (p *DataSource) EndTransaction() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.inTx && p.successful {
+		p.committed = p.pending
+	}
+	p.pending = nil
+	p.inTx = false
+	p.successful = false
+	return nil
+}
+
+func (p *DataSource) GetChangeSetFileSystem() (fs.FS, error) {
+	return p.setFS, nil
+}
+
+func (p *DataSource) GetMigrationInfo() (*dsync.MigrationInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var currentVersion int64
+
+	p.created = true
+	migrations := append([]dsync.Migration(nil), *p.records()...)
+	sort.SliceStable(migrations, func(i, j int) bool {
+		if migrations[i].Version != migrations[j].Version {
+			return migrations[i].Version < migrations[j].Version
+		}
+		return migrations[i].Id < migrations[j].Id
+	})
+	if l := len(migrations); l > 0 {
+		currentVersion = migrations[l-1].Version
+	}
+	return &dsync.MigrationInfo{TableName: p.tablename, Migrations: migrations, Version: currentVersion}, nil
+}
+
+// TableName Returns the name of the migration table, see dsync.TableInspector
+func (p *DataSource) TableName() string {
+	return p.tablename
+}
+
+// TableExists Reports whether GetMigrationInfo has been called, standing for the creation of the migration table,
+// or migrations have been recorded. See dsync.TableInspector.
+func (p *DataSource) TableExists() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.created || len(p.committed) > 0, nil
+}
+
+func (p *DataSource) ApplyMigration(m *dsync.Migration) error {
+	m.Success = false
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+
+	f, err := dsync.OpenMigrationFile(p.setFS, filepath.Join(p.basepath, m.File))
+	if err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+	defer f.Close()
+
+	script, err := dsync.RenderTemplate(m.File, dsync.UpSection(f), m.TemplateData)
+	if err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	started := time.Now()
+	if err := p.exec(script, m.Placeholders); err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+	if err := p.insertBlobs(m); err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+	if err := p.failures[m.File]; err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+	m.Success = true
+	m.Duration = time.Since(started)
+
+	records := p.records()
+	if m.Id != 0 {
+		// changed repeatable migration, replace its record
+		p.remove(records, m.Id)
+	}
+	p.nextId++
+	m.Id = p.nextId
+	*records = append(*records, *m)
+	return nil
+}
+
+// exec Record the statements of a script, with their placeholders expanded
+func (p *DataSource) exec(script io.Reader, placeholders map[string]string) error {
+	scanner := dsync.NewStatementScanner(script)
+	for {
+		statement, err := scanner.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if statement, err = dsync.ExpandPlaceholders(statement, placeholders); err != nil {
+			return err
+		}
+		p.statements = append(p.statements, statement)
+	}
+}
+
+// insertBlobs Read the data files declared by the blob directives of a migration and record their insertions, with
+// the data left as a parameter
+func (p *DataSource) insertBlobs(m *dsync.Migration) error {
+	for _, blob := range m.Blobs {
+		if _, err := dsync.ReadBlob(p.setFS, p.basepath, m, blob); err != nil {
+			return err
+		}
+		p.statements = append(p.statements, "INSERT INTO "+blob.Table+" ("+blob.Column+") VALUES ($1)")
+	}
+	return nil
+}
+
+func (p *DataSource) remove(records *[]dsync.Migration, id uint32) {
+	for i := range *records {
+		if (*records)[i].Id == id {
+			*records = append((*records)[:i:i], (*records)[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *DataSource) RevertMigration(m *dsync.Migration, script string) error {
+	return p.RevertMigrationContext(context.Background(), m, script)
+}
+
+// RevertMigrationContext RevertMigration, see dsync.Reverter
+func (p *DataSource) RevertMigrationContext(ctx context.Context, m *dsync.Migration, script string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.exec(strings.NewReader(script), m.Placeholders); err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+	if err := p.failures[m.File]; err != nil {
+		return &dsync.MigrationError{Err: err, Migration: m}
+	}
+	p.remove(p.records(), m.Id)
+	return nil
+}
+
+func (p *DataSource) UpdateChecksum(m *dsync.Migration) error {
+	return p.UpdateChecksumContext(context.Background(), m)
+}
+
+// UpdateChecksumContext UpdateChecksum, see dsync.ChecksumRepairer
+func (p *DataSource) UpdateChecksumContext(ctx context.Context, m *dsync.Migration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	records := *p.records()
+	for i := range records {
+		if records[i].Id == m.Id {
+			records[i].Checksum = m.Checksum
+			records[i].Digest = m.Digest
+			records[i].Algorithm = m.Algorithm
+			records[i].Size = m.Size
+			return nil
+		}
+	}
+	return &dsync.MigrationError{Err: errors.New("no such migration record"), Migration: m}
+}
+
+// RecordMigration Record the migration as successfully applied without executing its script
+func (p *DataSource) RecordMigration(m *dsync.Migration) error {
+	return p.RecordMigrationContext(context.Background(), m)
+}
+
+// RecordMigrationContext RecordMigration, see dsync.MigrationRecorder
+func (p *DataSource) RecordMigrationContext(ctx context.Context, m *dsync.Migration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m.Success = true
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	p.nextId++
+	m.Id = p.nextId
+	records := p.records()
+	*records = append(*records, *m)
+	return nil
+}
+
+func (p *DataSource) GetPath() string {
+	return p.basepath
+}
+
+func (p *DataSource) GetPaths() []string {
+	return p.basepaths
+}
+
+func (p *DataSource) GetExtensions() []string {
+	return p.extensions
+}
+
+// Handle There is no database, always nil
+func (p *DataSource) Handle() *sql.DB {
+	return nil
+}
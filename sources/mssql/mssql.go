@@ -0,0 +1,281 @@
+package mssql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/SharkFourSix/dsync"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+type mssqlDataSource struct {
+	dsync.SQLSource
+	lockConn *sql.Conn
+}
+
+// dialect SQL Server flavour of the migration table. Each statement of a migration is sent as its own batch, and
+// GO separators are not supported. The body of a CREATE PROCEDURE (or TRIGGER, FUNCTION) is split on its semicolons
+// unless it is wrapped in BEGIN ... END, or the statement ends with a delimiter set by a DELIMITER directive
+// (e.g. DELIMITER $$), see dsync.SplitStatements.
+type dialect struct{}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return dsync.QuoteIdentifier(name, "[", "]")
+}
+
+func (dialect) Placeholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+// CreateAuditTableStatement TIMESTAMP is a row version and BOOLEAN does not exist in SQL Server, see
+// dsync.AuditTableCreator
+func (dialect) CreateAuditTableStatement(table string) string {
+	return "CREATE TABLE " + table + ` (StartedAt DATETIME2 NOT NULL
+		, FinishedAt DATETIME2 NOT NULL
+		, DurationMs BIGINT NOT NULL
+		, AppliedBy NVARCHAR(255) NOT NULL
+		, Migrations NVARCHAR(MAX) NOT NULL
+		, Success BIT NOT NULL
+		, Error NVARCHAR(MAX))`
+}
+
+// QuoteColumns FILE is a reserved word in T-SQL and has to be quoted
+func (dialect) QuoteColumns() bool {
+	return true
+}
+
+func (dialect) CreateTableStatement(table string, columns dsync.MigrationColumns) string {
+	return "CREATE TABLE " + table + "(" + columns.Id + " INT IDENTITY(1,1) PRIMARY KEY" +
+		", " + columns.Name + " NVARCHAR(MAX) NOT NULL" +
+		", " + columns.File + " NVARCHAR(MAX) NOT NULL" +
+		", " + columns.Version + " BIGINT NOT NULL" +
+		", " + columns.CreatedAt + " DATETIMEOFFSET" +
+		", " + columns.Checksum + " BIGINT NOT NULL" +
+		", " + columns.VersionLabel + " NVARCHAR(255)" +
+		", " + columns.Digest + " NVARCHAR(255)" +
+		", " + columns.Success + " BIT" +
+		", " + columns.DurationMs + " BIGINT" +
+		", " + columns.Algorithm + " NVARCHAR(16)" +
+		", " + columns.AppliedBy + " NVARCHAR(255)" +
+		", " + columns.Size + " BIGINT" +
+		", " + columns.NoOp + " BIT)"
+}
+
+func (dialect) TableExistsQuery(name string) (string, []interface{}) {
+	q := `SELECT CASE WHEN EXISTS (SELECT 1
+		FROM sys.tables
+		WHERE name = @p1
+		AND schema_id = SCHEMA_ID()
+	) THEN 1 ELSE 0 END`
+	return q, []interface{}{name}
+}
+
+func (dialect) ServerVersionQuery() string {
+	return "SELECT CAST(SERVERPROPERTY('ProductVersion') AS NVARCHAR(128))"
+}
+
+func (dialect) Upgrades() []dsync.ColumnUpgrade {
+	return []dsync.ColumnUpgrade{
+		{Column: "VersionLabel", Definition: "NVARCHAR(255)"},
+		{Column: "Digest", Definition: "NVARCHAR(255)"},
+		{Column: "Success", Definition: "BIT"},
+		{Column: "DurationMs", Definition: "BIGINT"},
+		{Column: "Algorithm", Definition: "NVARCHAR(16)"},
+		{Column: "AppliedBy", Definition: "NVARCHAR(255)"},
+		{Column: "Size", Definition: "BIGINT"},
+		{Column: "NoOp", Definition: "BIT"},
+	}
+}
+
+// ColumnExists Reports whether the migration table has a column
+func (dialect) ColumnExists(ctx context.Context, b *dsync.SQLSource, column string) (bool, error) {
+	q := `SELECT CASE WHEN COL_LENGTH(@p1, @p2) IS NULL THEN 0 ELSE 1 END`
+	var exists bool
+	err := b.Writer().QueryRowContext(ctx, q, b.TableName(), column).Scan(&exists)
+	return exists, err
+}
+
+func (dialect) AddColumnStatement(table string, column string, definition string) string {
+	return "ALTER TABLE " + table + " ADD " + column + " " + definition
+}
+
+// New Open a connection to the database identified by dsn and create a data source over it
+func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
+	if err := dsync.ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlserver", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ds, err := Wrap(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	ds.(*mssqlDataSource).Owned = true
+	return ds, nil
+}
+
+// Wrap Create a data source over an existing database handle
+func Wrap(db *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	src, err := dsync.NewSQLSource(db, dialect{}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &mssqlDataSource{SQLSource: *src}, nil
+}
+
+// WrapRW Create a data source reading the migration table from readDB, e.g. a read replica, and writing it, as
+// well as applying migrations, through writeDB. See Wrap.
+func WrapRW(readDB *sql.DB, writeDB *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	ds, err := Wrap(writeDB, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ds.(*mssqlDataSource).Reads = readDB
+	return ds, nil
+}
+
+// Lock Take a session owned application lock (sp_getapplock) named after the table. The lock is held on a
+// dedicated connection since session locks belong to the session that acquired them.
+func (p *mssqlDataSource) Lock(ctx context.Context) error {
+	if p.lockConn != nil {
+		return errors.New("already locked")
+	}
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	var result int
+	q := `DECLARE @result INT;
+		EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = -1;
+		SELECT @result`
+	if err := conn.QueryRowContext(ctx, q, p.lockName()).Scan(&result); err != nil {
+		conn.Close()
+		return err
+	}
+	if result < 0 {
+		conn.Close()
+		return errors.New("could not acquire lock " + p.lockName())
+	}
+	p.lockConn = conn
+	return nil
+}
+
+func (p *mssqlDataSource) Unlock() error {
+	if p.lockConn == nil {
+		return nil
+	}
+	_, err := p.lockConn.ExecContext(context.Background(), `EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'`, p.lockName())
+	if cerr := p.lockConn.Close(); err == nil {
+		err = cerr
+	}
+	p.lockConn = nil
+	return err
+}
+
+func (p mssqlDataSource) lockName() string {
+	return "dsync." + p.TableName()
+}
+
+// lockHolder Returns the id of the session holding the application lock, 0 when it is not held. sys.dm_tran_locks
+// only reports the first 32 characters of the resource name.
+func (p mssqlDataSource) lockHolder(ctx context.Context) (int64, error) {
+	var id int64
+	q := `SELECT TOP 1 request_session_id FROM sys.dm_tran_locks
+		WHERE resource_type = 'APPLICATION' AND request_status = 'GRANT' AND resource_database_id = DB_ID()
+		AND CHARINDEX(':[' + LEFT(@p1, 32), resource_description) > 0`
+	err := p.DB.QueryRowContext(ctx, q, p.lockName()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// LockStatus Report the session holding the application lock, see dsync.LockBreaker
+func (p mssqlDataSource) LockStatus(ctx context.Context) (dsync.LockStatus, error) {
+	id, err := p.lockHolder(ctx)
+	if err != nil || id == 0 {
+		return dsync.LockStatus{}, err
+	}
+	return dsync.LockStatus{Held: true, Owner: "session " + strconv.FormatInt(id, 10)}, nil
+}
+
+// ForceUnlock Kill the session holding the application lock, see dsync.LockBreaker
+func (p mssqlDataSource) ForceUnlock(ctx context.Context) error {
+	id, err := p.lockHolder(ctx)
+	if err != nil || id == 0 {
+		return err
+	}
+	// KILL does not take parameters
+	_, err = p.DB.ExecContext(ctx, "KILL "+strconv.FormatInt(id, 10))
+	return err
+}
+
+// CheckIntegrity CheckIntegrityContext using context.Background()
+func (p mssqlDataSource) CheckIntegrity() error {
+	return p.CheckIntegrityContext(context.Background())
+}
+
+// CheckIntegrityContext see dsync.IntegrityChecker
+func (p mssqlDataSource) CheckIntegrityContext(ctx context.Context) error {
+	var violations []dsync.IntegrityViolation
+
+	// Constraints created or re-enabled WITH NOCHECK are not trusted: existing rows were never verified
+	r, err := p.DB.QueryContext(ctx, `SELECT OBJECT_NAME(parent_object_id), name, type_desc
+		FROM sys.foreign_keys
+		WHERE is_not_trusted = 1
+		UNION ALL
+		SELECT OBJECT_NAME(parent_object_id), name, type_desc
+		FROM sys.check_constraints
+		WHERE is_not_trusted = 1
+		ORDER BY 1, 2`,
+	)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for r.Next() {
+		var violation dsync.IntegrityViolation
+		var kind string
+		if err := r.Scan(&violation.Table, &violation.Constraint, &kind); err != nil {
+			return err
+		}
+		violation.Detail = "constraint is not trusted: " + strings.ToLower(kind)
+		violations = append(violations, violation)
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &dsync.IntegrityError{Violations: violations}
+	}
+	return nil
+}
+
+// IsTransient The errors Azure SQL documents as transient (database unavailable, service busy, failover in
+// progress), as well as dsync.IsTransientError
+func (p mssqlDataSource) IsTransient(err error) bool {
+	var merr mssql.Error
+	if errors.As(err, &merr) {
+		switch merr.Number {
+		case 4060, 4221, 10928, 10929, 40197, 40501, 40613, 49918, 49919, 49920:
+			return true
+		}
+		return false
+	}
+	return dsync.IsTransientError(err)
+}
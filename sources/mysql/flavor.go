@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/SharkFourSix/dsync"
+	driver "github.com/go-sql-driver/mysql"
+)
+
+// Flavor Server implementing the MySQL protocol
+type Flavor int
+
+const (
+	// MySQL Oracle MySQL, and compatible servers not identified as MariaDB
+	MySQL Flavor = iota
+	// MariaDB MariaDB, identified by the "MariaDB" suffix of its version (e.g. 10.6.12-MariaDB)
+	MariaDB
+)
+
+func (f Flavor) String() string {
+	switch f {
+	case MySQL:
+		return "mysql"
+	case MariaDB:
+		return "mariadb"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectFlavor Identify the server from the result of SELECT VERSION()
+func DetectFlavor(version string) Flavor {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return MariaDB
+	}
+	return MySQL
+}
+
+// FlavorSource is implemented by the data sources of this package, reporting the flavor detected by New and Wrap
+type FlavorSource interface {
+	Flavor() Flavor
+}
+
+func (p mysqlDataSource) Flavor() Flavor {
+	return p.flavor
+}
+
+// detectFlavor Query the version of the server
+func detectFlavor(ctx context.Context, db *sql.DB) (Flavor, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return MySQL, err
+	}
+	return DetectFlavor(version), nil
+}
+
+// TableExists Look the migration table up. information_schema intermittently misses existing tables on MariaDB
+// (10.6), which is queried for the table itself instead: error 1146 (ER_NO_SUCH_TABLE) means it does not exist.
+func (d dialect) TableExists(ctx context.Context, b *dsync.SQLSource) (bool, error) {
+	if d.flavor == MariaDB {
+		rows, err := b.Reader().QueryContext(ctx, "SELECT 1 FROM "+b.Table()+" LIMIT 0")
+		if err != nil {
+			if isNoSuchTable(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, rows.Close()
+	}
+
+	q, args := d.TableExistsQuery(b.TableName())
+	var exists bool
+	if err := b.Reader().QueryRowContext(ctx, q, args...).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// isNoSuchTable Error 1146 (ER_NO_SUCH_TABLE)
+func isNoSuchTable(err error) bool {
+	var merr *driver.MySQLError
+	return errors.As(err, &merr) && merr.Number == 1146
+}
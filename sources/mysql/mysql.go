@@ -1,196 +1,250 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"io"
-	"io/fs"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/SharkFourSix/dsync"
-	_ "github.com/go-sql-driver/mysql"
+	driver "github.com/go-sql-driver/mysql"
 )
 
 type mysqlDataSource struct {
-	db               *sql.DB
-	tx               *sql.Tx
-	basepath         string
-	successful       bool
-	setFS            fs.FS
-	tablename        string
-	createTableQuery string
-	selectionQuery   string
-	insertionQuery   string
+	dsync.SQLSource
+	lockConn *sql.Conn
+	flavor   Flavor
 }
 
-func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
-	var err error
-	var sb strings.Builder
+// dialect MySQL flavour of the migration table
+type dialect struct {
+	flavor Flavor
+}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return dsync.QuoteIdentifier(name, "`", "`")
+}
+
+func (dialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (d dialect) CreateTableStatement(table string, columns dsync.MigrationColumns) string {
+	create := "CREATE TABLE "
+	if d.flavor == MariaDB {
+		// guards against a stale existence check
+		create += "IF NOT EXISTS "
+	}
+	return create + table + "(" + columns.Id + " INT NOT NULL PRIMARY KEY AUTO_INCREMENT" +
+		", " + columns.Name + " TEXT NOT NULL" +
+		", " + columns.File + " TEXT NOT NULL" +
+		", " + columns.Version + " BIGINT NOT NULL" +
+		", " + columns.CreatedAt + " TIMESTAMP" +
+		", " + columns.Checksum + " BIGINT NOT NULL" +
+		", " + columns.VersionLabel + " VARCHAR(255)" +
+		", " + columns.Digest + " VARCHAR(255)" +
+		", " + columns.Success + " BOOLEAN" +
+		", " + columns.DurationMs + " BIGINT" +
+		", " + columns.Algorithm + " VARCHAR(16)" +
+		", " + columns.AppliedBy + " VARCHAR(255)" +
+		", " + columns.Size + " BIGINT" +
+		", " + columns.NoOp + " BOOLEAN)"
+}
 
-	ds := &mysqlDataSource{
-		tablename:  cfg.TableNameOrDefault(),
-		basepath:   cfg.Basepath,
-		setFS:      cfg.FileSystem,
-		successful: false,
+func (dialect) TableExistsQuery(name string) (string, []interface{}) {
+	q := `SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?)`
+	return q, []interface{}{name}
+}
+
+func (dialect) ServerVersionQuery() string {
+	return "SELECT VERSION()"
+}
+
+func (dialect) Upgrades() []dsync.ColumnUpgrade {
+	return []dsync.ColumnUpgrade{
+		{Column: "VersionLabel", Definition: "VARCHAR(255)"},
+		{Column: "Digest", Definition: "VARCHAR(255)"},
+		{Column: "Success", Definition: "BOOLEAN"},
+		{Column: "DurationMs", Definition: "BIGINT"},
+		{Column: "Algorithm", Definition: "VARCHAR(16)"},
+		{Column: "AppliedBy", Definition: "VARCHAR(255)"},
+		{Column: "Size", Definition: "BIGINT"},
+		{Column: "NoOp", Definition: "BOOLEAN"},
 	}
+}
+
+// ColumnExists Reports whether the migration table has a column, for both flavors
+func (dialect) ColumnExists(ctx context.Context, b *dsync.SQLSource, column string) (bool, error) {
+	q := `SELECT EXISTS(SELECT 1 FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?)`
+	var exists bool
+	err := b.Writer().QueryRowContext(ctx, q, b.TableName(), column).Scan(&exists)
+	return exists, err
+}
 
-	if err = dsync.ValidateConfig(cfg); err != nil {
+// AddColumnStatement MariaDB adds the column only if it is missing, guarding against a stale ColumnExists
+func (d dialect) AddColumnStatement(table string, column string, definition string) string {
+	if d.flavor == MariaDB {
+		return "ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS " + column + " " + definition
+	}
+	return "ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition
+}
+
+// ParseTimestamp see timestamp
+func (dialect) ParseTimestamp(value interface{}) (time.Time, error) {
+	var ts timestamp
+	err := ts.Scan(value)
+	return ts.Time, err
+}
+
+// FormatTimestamp CreatedAt is written as is
+func (dialect) FormatTimestamp(t time.Time) interface{} {
+	return t
+}
+
+// New Open a connection to the database identified by dsn and create a data source over it. parseTime=true is
+// added to the dsn when missing so that TIMESTAMP columns are read as time.Time.
+func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
+	if err := dsync.ValidateConfig(cfg); err != nil {
 		return nil, err
 	}
 
-	ds.db, err = sql.Open("mysql", dsn)
+	dsnConfig, err := driver.ParseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
+	dsnConfig.ParseTime = true
 
-	if err := ds.db.Ping(); err != nil {
+	db, err := sql.Open("mysql", dsnConfig.FormatDSN())
+	if err != nil {
 		return nil, err
 	}
 
-	sb.WriteString("CREATE TABLE `")
-	sb.WriteString(ds.tablename)
-	sb.WriteString("`")
-	sb.WriteString(`(Id INT NOT NULL PRIMARY KEY AUTO_INCREMENT
-		, Name TEXT NOT NULL
-		, File TEXT NOT NULL
-		, Version BIGINT NOT NULL
-		, CreatedAt TIMESTAMP
-		, Checksum BIGINT NOT NULL)`,
-	)
-	ds.createTableQuery = sb.String()
-	sb.Reset()
-
-	sb.WriteString("SELECT Id, Name, File, Version, CreatedAt, Checksum FROM `")
-	sb.WriteString(ds.tablename)
-	sb.WriteString("` ORDER BY Version ASC")
-	ds.selectionQuery = sb.String()
-	sb.Reset()
-
-	sb.WriteString("INSERT INTO `")
-	sb.WriteString(ds.tablename)
-	sb.WriteString("`")
-	sb.WriteString(`(Name, File, Version, CreatedAt, Checksum) VALUES (?, ?, ?, ?, ?)`)
-	ds.insertionQuery = sb.String()
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
+	ds, err := Wrap(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	ds.(*mysqlDataSource).Owned = true
 	return ds, nil
 }
 
-func (p *mysqlDataSource) BeginTransaction() error {
-	if p.tx != nil {
-		return errors.New("already in transaction")
+// Wrap Create a data source over an existing database handle. The handle does not need parseTime=true, but should
+// use the same loc for reads and writes (the default, UTC, does). The server is queried for its Flavor.
+func Wrap(db *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	if err := dsync.ValidateConfig(cfg); err != nil {
+		return nil, err
 	}
-	tx, err := p.db.Begin()
+	flavor, err := detectFlavor(context.Background(), db)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	p.tx = tx
-	return nil
-}
-
-func (p *mysqlDataSource) SetTransactionSuccessful(b bool) {
-	p.successful = b
-}
-
-func (p mysqlDataSource) EndTransaction() {
-	if p.successful {
-		p.tx.Commit()
-	} else {
-		p.tx.Rollback()
+	src, err := dsync.NewSQLSource(db, dialect{flavor: flavor}, cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &mysqlDataSource{SQLSource: *src, flavor: flavor}, nil
 }
 
-func (p mysqlDataSource) GetChangeSetFileSystem() (fs.FS, error) {
-	return p.setFS, nil
-}
-
-func (p mysqlDataSource) GetMigrationInfo() (*dsync.MigrationInfo, error) {
-	// Connect
-	q := `SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?)`
-	var currentVersion int64
-	var exists bool
-	if err := p.db.QueryRow(q, p.tablename).Scan(&exists); err != nil {
+// WrapRW Create a data source reading the migration table from readDB, e.g. a read replica, and writing it, as
+// well as applying migrations, through writeDB. See Wrap.
+func WrapRW(readDB *sql.DB, writeDB *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	ds, err := Wrap(writeDB, cfg)
+	if err != nil {
 		return nil, err
 	}
+	ds.(*mysqlDataSource).Reads = readDB
+	return ds, nil
+}
 
-	if exists {
-		var migrations []dsync.Migration
-		r, err := p.db.Query(p.selectionQuery)
-		if err != nil {
-			return nil, err
-		}
-		for r.Next() {
-			var migration dsync.Migration
-			err := r.Scan(&migration.Id, &migration.Name, &migration.File, &migration.Version, &migration.CreatedAt, &migration.Checksum)
-			if err != nil {
-				return nil, err
-			}
-			migrations = append(migrations, migration)
-		}
-		l := len(migrations)
-		if l > 0 {
-			currentVersion = migrations[l-1].Version
-		}
-		return &dsync.MigrationInfo{TableName: p.tablename, Migrations: migrations, Version: currentVersion}, nil
-	} else {
-		_, err := p.db.Exec(p.createTableQuery)
-		if err != nil {
-			return nil, err
-		}
-		return &dsync.MigrationInfo{
-			TableName: p.tablename,
-		}, nil
-	}
-}
-
-func (p mysqlDataSource) ApplyMigration(m *dsync.Migration) error {
-	var buf []byte
-	var sb strings.Builder
-	f, err := p.setFS.Open(filepath.Join(p.basepath, m.File))
-
-	m.Success = false
-	m.CreatedAt = time.Now()
-
+// Lock Take a named lock (GET_LOCK) derived from the table name. The lock is held on a dedicated
+// connection since named locks belong to the session that acquired them.
+func (p *mysqlDataSource) Lock(ctx context.Context) error {
+	if p.lockConn != nil {
+		return errors.New("already locked")
+	}
+	conn, err := p.DB.Conn(ctx)
 	if err != nil {
+		return err
+	}
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", p.lockName()).Scan(&acquired); err != nil {
+		conn.Close()
+		return err
+	}
+	if acquired.Int64 != 1 {
+		conn.Close()
+		return errors.New("could not acquire lock " + p.lockName())
+	}
+	p.lockConn = conn
+	return nil
+}
+
+func (p *mysqlDataSource) Unlock() error {
+	if p.lockConn == nil {
 		return nil
 	}
+	_, err := p.lockConn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", p.lockName())
+	if cerr := p.lockConn.Close(); err == nil {
+		err = cerr
+	}
+	p.lockConn = nil
+	return err
+}
 
-	defer f.Close()
+func (p mysqlDataSource) lockName() string {
+	return "dsync." + p.TableName()
+}
 
-	buf = make([]byte, 1024)
-	for {
-		l, err := f.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				query := sb.String()
-				_, err := p.tx.Exec(query)
-				if err != nil {
-					return &dsync.MigrationError{Err: err, Migration: m}
-				}
-				m.Success = true
-				return p.logMigration(m)
-			} else {
-				return &dsync.MigrationError{Err: err, Migration: m}
-			}
-		} else {
-			sb.Write(buf[:l])
-		}
+// lockHolder Returns the id of the connection holding the named lock, 0 when it is not held
+func (p mysqlDataSource) lockHolder(ctx context.Context) (int64, error) {
+	var id sql.NullInt64
+	if err := p.DB.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?)", p.lockName()).Scan(&id); err != nil {
+		return 0, err
 	}
+	return id.Int64, nil
 }
 
-func (p mysqlDataSource) GetPath() string {
-	return p.basepath
+// LockStatus Report the connection holding the named lock, see dsync.LockBreaker
+func (p mysqlDataSource) LockStatus(ctx context.Context) (dsync.LockStatus, error) {
+	id, err := p.lockHolder(ctx)
+	if err != nil || id == 0 {
+		return dsync.LockStatus{}, err
+	}
+	return dsync.LockStatus{Held: true, Owner: "connection " + strconv.FormatInt(id, 10)}, nil
 }
 
-func (p mysqlDataSource) logMigration(m *dsync.Migration) error {
-	_, err := p.tx.Exec(p.insertionQuery, m.Name, m.File, m.Version, m.CreatedAt, m.Checksum)
-	if err != nil {
-		return &dsync.MigrationError{Err: err, Migration: m}
+// ForceUnlock Kill the connection holding the named lock, see dsync.LockBreaker
+func (p mysqlDataSource) ForceUnlock(ctx context.Context) error {
+	id, err := p.lockHolder(ctx)
+	if err != nil || id == 0 {
+		return err
 	}
-	return nil
+	// KILL does not take parameters
+	_, err = p.DB.ExecContext(ctx, "KILL "+strconv.FormatInt(id, 10))
+	return err
 }
 
-func (ds mysqlDataSource) Handle() *sql.DB {
-	return ds.db
+// PreferredTransactionMode MySQL commits implicitly before and after DDL statements. Committing after each
+// migration keeps the migration table in line with the schema when a later migration fails.
+func (p mysqlDataSource) PreferredTransactionMode() dsync.TransactionMode {
+	return dsync.PerMigration
+}
+
+// IsTransient Invalid connections, too many connections (1040), server shutdowns (1053) and killed connections
+// (1927), as well as dsync.IsTransientError
+func (p mysqlDataSource) IsTransient(err error) bool {
+	if errors.Is(err, driver.ErrInvalidConn) {
+		return true
+	}
+	var merr *driver.MySQLError
+	if errors.As(err, &merr) {
+		return merr.Number == 1040 || merr.Number == 1053 || merr.Number == 1927
+	}
+	return dsync.IsTransientError(err)
 }
@@ -0,0 +1,48 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+)
+
+// layouts DATETIME and TIMESTAMP text formats returned by the driver when parseTime is not set
+var layouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// timestamp Scans a CreatedAt column whether or not the connection was opened with parseTime=true. Raw values are
+// read in UTC, the driver's default location.
+type timestamp struct {
+	time.Time
+}
+
+func (ts *timestamp) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		ts.Time = time.Time{}
+		return nil
+	case time.Time:
+		ts.Time = v.UTC()
+		return nil
+	case []byte:
+		return ts.parse(string(v))
+	case string:
+		return ts.parse(v)
+	}
+	return fmt.Errorf("unsupported timestamp type %T", value)
+}
+
+func (ts *timestamp) parse(s string) error {
+	if s == "0000-00-00 00:00:00" {
+		ts.Time = time.Time{}
+		return nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			ts.Time = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized timestamp %q", s)
+}
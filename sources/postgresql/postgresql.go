@@ -1,203 +1,317 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"io"
-	"io/fs"
-	"path/filepath"
+	"hash/crc32"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/SharkFourSix/dsync"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type pgDataSource struct {
-	db               *sql.DB
-	tx               *sql.Tx
-	basepath         string
-	successful       bool
-	setFS            fs.FS
-	tablename        string
-	createTableQuery string
-	selectionQuery   string
-	insertionQuery   string
+	dsync.SQLSource
+	lockConn *sql.Conn
+	// lockID Key of the advisory lock held on lockConn
+	lockID int64
 }
 
-func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
-	var err error
-	var sb strings.Builder
+// dialect Postgres flavour of the migration table
+type dialect struct{}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return dsync.QuoteIdentifier(name, `"`, `"`)
+}
+
+func (dialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// QuoteTable see quoteTable
+func (dialect) QuoteTable(name string) string {
+	return quoteTable(name)
+}
+
+func (dialect) CreateTableStatement(table string, columns dsync.MigrationColumns) string {
+	return "CREATE TABLE " + table + "(" + columns.Id + " SERIAL PRIMARY KEY" +
+		", " + columns.Name + " TEXT NOT NULL" +
+		", " + columns.File + " TEXT NOT NULL" +
+		", " + columns.Version + " BIGINT NOT NULL" +
+		", " + columns.CreatedAt + " timestamptz" +
+		", " + columns.Checksum + " BIGINT NOT NULL" +
+		", " + columns.VersionLabel + " TEXT" +
+		", " + columns.Digest + " TEXT" +
+		", " + columns.Success + " BOOLEAN" +
+		", " + columns.DurationMs + " BIGINT" +
+		", " + columns.Algorithm + " TEXT" +
+		", " + columns.AppliedBy + " TEXT" +
+		", " + columns.Size + " BIGINT" +
+		", " + columns.NoOp + " BOOLEAN)"
+}
+
+// TableExistsQuery Looks the table up in its schema, the current one for unqualified names
+func (dialect) TableExistsQuery(name string) (string, []interface{}) {
+	q := `select exists(select 1
+		from information_schema."tables"
+		where is_insertable_into = 'YES'
+		and table_type = 'BASE TABLE'
+		and table_catalog = CURRENT_CATALOG
+		and table_name = $1
+		and table_schema = coalesce(nullif($2, ''), current_schema())
+	)`
+	schema, table := splitTableName(name)
+	return q, []interface{}{table, schema}
+}
+
+func (dialect) ServerVersionQuery() string {
+	return "SHOW server_version"
+}
 
-	ds := &pgDataSource{
-		tablename:  cfg.TableNameOrDefault(),
-		basepath:   cfg.Basepath,
-		setFS:      cfg.FileSystem,
-		successful: false,
+func (dialect) Upgrades() []dsync.ColumnUpgrade {
+	return []dsync.ColumnUpgrade{
+		{Column: "VersionLabel", Definition: "TEXT"},
+		{Column: "Digest", Definition: "TEXT"},
+		{Column: "Success", Definition: "BOOLEAN"},
+		{Column: "DurationMs", Definition: "BIGINT"},
+		{Column: "Algorithm", Definition: "TEXT"},
+		{Column: "AppliedBy", Definition: "TEXT"},
+		{Column: "Size", Definition: "BIGINT"},
+		{Column: "NoOp", Definition: "BOOLEAN"},
 	}
+}
+
+// ColumnExists Reports whether the migration table has a column
+func (dialect) ColumnExists(ctx context.Context, b *dsync.SQLSource, column string) (bool, error) {
+	q := `select exists(select 1
+		from information_schema.columns
+		where table_catalog = CURRENT_CATALOG
+		and table_name = $1
+		and table_schema = coalesce(nullif($3, ''), current_schema())
+		and lower(column_name) = lower($2)
+	)`
+	var exists bool
+	schema, table := splitTableName(b.TableName())
+	err := b.Writer().QueryRowContext(ctx, q, table, column, schema).Scan(&exists)
+	return exists, err
+}
 
-	if err = dsync.ValidateConfig(cfg); err != nil {
+func (dialect) AddColumnStatement(table string, column string, definition string) string {
+	return "ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition
+}
+
+// New Open a connection to the database identified by dsn and create a data source over it
+func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
+	if err := dsync.ValidateConfig(cfg); err != nil {
 		return nil, err
 	}
 
-	ds.db, err = sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := ds.db.Ping(); err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	sb.WriteString(`CREATE TABLE "`)
-	sb.WriteString(ds.tablename)
-	sb.WriteString(`"`)
-	sb.WriteString(`(Id SERIAL PRIMARY KEY
-		, Name TEXT NOT NULL
-		, File TEXT NOT NULL
-		, Version BIGINT NOT NULL
-		, CreatedAt timestamptz
-		, Checksum BIGINT NOT NULL)`,
-	)
-	ds.createTableQuery = sb.String()
-	sb.Reset()
-
-	sb.WriteString(`SELECT Id, Name, File, Version, CreatedAt, Checksum FROM "`)
-	sb.WriteString(ds.tablename)
-	sb.WriteString(`" ORDER BY Version ASC`)
-	ds.selectionQuery = sb.String()
-	sb.Reset()
+	ds, err := Wrap(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	ds.(*pgDataSource).Owned = true
+	return ds, nil
+}
 
-	sb.WriteString(`INSERT INTO "`)
-	sb.WriteString(ds.tablename)
-	sb.WriteString(`"`)
-	sb.WriteString(`(Name, File, Version, CreatedAt, Checksum) VALUES ($1, $2, $3, $4, $5)`)
-	ds.insertionQuery = sb.String()
+// Wrap Create a data source over an existing database handle
+func Wrap(db *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	src, err := dsync.NewSQLSource(db, dialect{}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &pgDataSource{SQLSource: *src}, nil
+}
 
+// WrapRW Create a data source reading the migration table from readDB, e.g. a read replica, and writing it, as
+// well as applying migrations, through writeDB. See Wrap.
+func WrapRW(readDB *sql.DB, writeDB *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	ds, err := Wrap(writeDB, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ds.(*pgDataSource).Reads = readDB
 	return ds, nil
 }
 
-func (p *pgDataSource) BeginTransaction() error {
-	if p.tx != nil {
-		return errors.New("already in transaction")
+// quoteTable Quoted name of a table. The schema and table of a schema qualified name (schema.table) are quoted
+// separately.
+func quoteTable(name string) string {
+	schema, table := splitTableName(name)
+	if schema == "" {
+		return dsync.QuoteIdentifier(table, `"`, `"`)
+	}
+	return dsync.QuoteIdentifier(schema, `"`, `"`) + "." + dsync.QuoteIdentifier(table, `"`, `"`)
+}
+
+// splitTableName Returns the schema, empty for unqualified names resolved against the search_path, and the table
+// of a table name
+func splitTableName(name string) (string, string) {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return schema, table
+	}
+	return "", name
+}
+
+// Lock Take a session level advisory lock keyed off the schema qualified table name. The lock is held on a
+// dedicated connection since advisory locks belong to the session that acquired them.
+func (p *pgDataSource) Lock(ctx context.Context) error {
+	if p.lockConn != nil {
+		return errors.New("already locked")
+	}
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	key, err := p.lockKey(ctx, conn)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key)
 	}
-	tx, err := p.db.Begin()
 	if err != nil {
+		conn.Close()
 		return err
 	}
-	p.tx = tx
+	p.lockConn, p.lockID = conn, key
 	return nil
 }
 
-func (p *pgDataSource) SetTransactionSuccessful(b bool) {
-	p.successful = b
+func (p *pgDataSource) Unlock() error {
+	if p.lockConn == nil {
+		return nil
+	}
+	_, err := p.lockConn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", p.lockID)
+	if cerr := p.lockConn.Close(); err == nil {
+		err = cerr
+	}
+	p.lockConn = nil
+	return err
 }
 
-func (p pgDataSource) EndTransaction() {
-	if p.successful {
-		p.tx.Commit()
-	} else {
-		p.tx.Rollback()
+// lockKey Key of the advisory lock, derived from the schema qualified table name so that migration tables of the
+// same name in different schemas (e.g. one per tenant) are locked independently. Unqualified names are resolved
+// against current_schema() of the session.
+func (p pgDataSource) lockKey(ctx context.Context, q dsync.Queryer) (int64, error) {
+	var schema sql.NullString
+	qualified, table := splitTableName(p.TableName())
+	if err := q.QueryRowContext(ctx, "SELECT coalesce(nullif($1, ''), current_schema())", qualified).Scan(&schema); err != nil {
+		return 0, err
 	}
+	return int64(crc32.ChecksumIEEE([]byte(schema.String + "." + table))), nil
 }
 
-func (p pgDataSource) GetChangeSetFileSystem() (fs.FS, error) {
-	return p.setFS, nil
+// lockHolder Returns the process id of the server session holding the advisory lock, 0 when it is not held. A
+// bigint advisory key is reported by pg_locks split into classid (high bits) and objid (low bits).
+func (p pgDataSource) lockHolder(ctx context.Context) (int64, error) {
+	var pid int64
+	key, err := p.lockKey(ctx, p.DB)
+	if err != nil {
+		return 0, err
+	}
+	q := `SELECT pid FROM pg_locks
+		WHERE locktype = 'advisory' AND granted AND objsubid = 1
+		AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		AND classid::bigint = $1::bigint >> 32 AND objid::bigint = $1::bigint & 4294967295
+		LIMIT 1`
+	err = p.DB.QueryRowContext(ctx, q, key).Scan(&pid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return pid, err
 }
 
-func (p pgDataSource) GetMigrationInfo() (*dsync.MigrationInfo, error) {
-	// Connect
-	q := `select exists(select 1
-		from information_schema."tables"
-		where is_insertable_into = 'YES' 
-		and table_type = 'BASE TABLE' 
-		and table_catalog = CURRENT_CATALOG 
-		and table_name = $1 
-	)	
-	`
-	var currentVersion int64
-	var exists bool
-	if err := p.db.QueryRow(q, p.tablename).Scan(&exists); err != nil {
-		return nil, err
+// LockStatus Report the server session holding the advisory lock, see dsync.LockBreaker
+func (p pgDataSource) LockStatus(ctx context.Context) (dsync.LockStatus, error) {
+	pid, err := p.lockHolder(ctx)
+	if err != nil || pid == 0 {
+		return dsync.LockStatus{}, err
 	}
+	return dsync.LockStatus{Held: true, Owner: "pid " + strconv.FormatInt(pid, 10)}, nil
+}
 
-	if exists {
-		var migrations []dsync.Migration
-		r, err := p.db.Query(p.selectionQuery)
-		if err != nil {
-			return nil, err
-		}
-		for r.Next() {
-			var migration dsync.Migration
-			err := r.Scan(&migration.Id, &migration.Name, &migration.File, &migration.Version, &migration.CreatedAt, &migration.Checksum)
-			if err != nil {
-				return nil, err
-			}
-			migrations = append(migrations, migration)
-		}
-		l := len(migrations)
-		if l > 0 {
-			currentVersion = migrations[l-1].Version
-		}
-		return &dsync.MigrationInfo{TableName: p.tablename, Migrations: migrations, Version: currentVersion}, nil
-	} else {
-		_, err := p.db.Exec(p.createTableQuery)
-		if err != nil {
-			return nil, err
-		}
-		return &dsync.MigrationInfo{
-			TableName: p.tablename,
-		}, nil
+// ForceUnlock Terminate the server session holding the advisory lock, see dsync.LockBreaker
+func (p pgDataSource) ForceUnlock(ctx context.Context) error {
+	pid, err := p.lockHolder(ctx)
+	if err != nil || pid == 0 {
+		return err
+	}
+	var terminated bool
+	if err := p.DB.QueryRowContext(ctx, "SELECT pg_terminate_backend($1)", pid).Scan(&terminated); err != nil {
+		return err
 	}
+	if !terminated {
+		return errors.New("could not terminate the session holding the lock, pid " + strconv.FormatInt(pid, 10))
+	}
+	return nil
 }
 
-func (p pgDataSource) ApplyMigration(m *dsync.Migration) error {
-	var buf []byte
-	var sb strings.Builder
-	f, err := p.setFS.Open(filepath.Join(p.basepath, m.File))
+// CheckIntegrity CheckIntegrityContext using context.Background()
+func (p pgDataSource) CheckIntegrity() error {
+	return p.CheckIntegrityContext(context.Background())
+}
 
-	m.Success = false
-	m.CreatedAt = time.Now()
+// CheckIntegrityContext see dsync.IntegrityChecker
+func (p pgDataSource) CheckIntegrityContext(ctx context.Context) error {
+	var violations []dsync.IntegrityViolation
 
+	// Constraints added with NOT VALID (or left unvalidated by a migration) are not enforced for existing rows.
+	// Only the schema of the migration table and the current schema are checked, not those of other applications.
+	schema, _ := splitTableName(p.TableName())
+	r, err := p.DB.QueryContext(ctx, `SELECT c.conrelid::regclass::text, c.conname, pg_get_constraintdef(c.oid)
+		FROM pg_constraint c
+		JOIN pg_namespace n ON n.oid = c.connamespace
+		WHERE NOT c.convalidated
+		AND c.contype IN ('f', 'c')
+		AND n.nspname IN (current_schema(), coalesce(nullif($1, ''), current_schema()))
+		ORDER BY c.conrelid::regclass::text, c.conname`,
+		schema,
+	)
 	if err != nil {
-		return nil
+		return err
 	}
+	defer r.Close()
 
-	defer f.Close()
-
-	buf = make([]byte, 1024)
-	for {
-		l, err := f.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				query := sb.String()
-				_, err := p.tx.Exec(query)
-				if err != nil {
-					return &dsync.MigrationError{Err: err, Migration: m}
-				}
-				m.Success = true
-				return p.logMigration(m)
-			} else {
-				return &dsync.MigrationError{Err: err, Migration: m}
-			}
-		} else {
-			sb.Write(buf[:l])
+	for r.Next() {
+		var violation dsync.IntegrityViolation
+		var definition string
+		if err := r.Scan(&violation.Table, &violation.Constraint, &definition); err != nil {
+			return err
 		}
+		violation.Detail = "constraint is not validated: " + definition
+		violations = append(violations, violation)
+	}
+	if err := r.Err(); err != nil {
+		return err
 	}
-}
-
-func (p pgDataSource) GetPath() string {
-	return p.basepath
-}
 
-func (p pgDataSource) logMigration(m *dsync.Migration) error {
-	_, err := p.tx.Exec(p.insertionQuery, m.Name, m.File, m.Version, m.CreatedAt, m.Checksum)
-	if err != nil {
-		return &dsync.MigrationError{Err: err, Migration: m}
+	if len(violations) > 0 {
+		return &dsync.IntegrityError{Violations: violations}
 	}
 	return nil
 }
 
-func (ds pgDataSource) Handle() *sql.DB {
-	return ds.db
+// IsTransient Connection exceptions (class 08) and server shutdowns or restarts (57P01, 57P02, 57P03), as well
+// as dsync.IsTransientError
+func (p pgDataSource) IsTransient(err error) bool {
+	var perr *pq.Error
+	if errors.As(err, &perr) {
+		switch perr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return perr.Code.Class() == "08"
+	}
+	return dsync.IsTransientError(err)
 }
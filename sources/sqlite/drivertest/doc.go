@@ -0,0 +1,3 @@
+// Package drivertest Tests the sqlite source over the sqlite drivers other than mattn/go-sqlite3. It is a module of
+// its own so that the dsync module does not depend on those drivers.
+package drivertest
@@ -0,0 +1,52 @@
+package drivertest_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/SharkFourSix/dsync"
+	"github.com/SharkFourSix/dsync/sources/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+func TestCreatedAtRoundTrip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001__a.sql": {Data: []byte(`CREATE TABLE a (id INTEGER);`)},
+	}
+	appliedAt := time.Date(2024, time.January, 15, 9, 30, 0, 123456789, time.UTC)
+
+	// mattn/go-sqlite3 registers "sqlite3", modernc.org/sqlite registers "sqlite"
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			db, err := sql.Open(driver, filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			ds, err := sqlite.Wrap(db, &dsync.Config{FileSystem: fsys, Basepath: "migrations"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			migrator := dsync.Migrator{Clock: func() time.Time { return appliedAt }}
+			if err := migrator.Migrate(ds); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := ds.GetMigrationInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(info.Migrations) != 1 {
+				t.Fatalf("expected 1 migration, got %d", len(info.Migrations))
+			}
+			if !info.Migrations[0].CreatedAt.Equal(appliedAt) {
+				t.Fatalf("expected CreatedAt %v, got %v", appliedAt, info.Migrations[0].CreatedAt)
+			}
+		})
+	}
+}
@@ -1,197 +1,298 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"io"
-	"io/fs"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/SharkFourSix/dsync"
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 type sqliteDataSource struct {
-	db               *sql.DB
-	tx               *sql.Tx
-	basepath         string
-	successful       bool
-	setFS            fs.FS
-	tablename        string
-	createTableQuery string
-	selectionQuery   string
-	insertionQuery   string
+	dsync.SQLSource
+	immediate bool
 }
 
-func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
-	var err error
-	var sb strings.Builder
+// dialect sqlite flavour of the migration table
+type dialect struct{}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return dsync.QuoteIdentifier(name, `"`, `"`)
+}
+
+func (dialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (dialect) CreateTableStatement(table string, columns dsync.MigrationColumns) string {
+	return "CREATE TABLE " + table + "(" + columns.Id + " INTEGER PRIMARY KEY AUTOINCREMENT" +
+		", " + columns.Name + " TEXT NOT NULL" +
+		", " + columns.File + " TEXT NOT NULL" +
+		", " + columns.Version + " INTEGER NOT NULL" +
+		", " + columns.CreatedAt + " TIMESTAMP" +
+		", " + columns.Checksum + " INTEGER NOT NULL" +
+		", " + columns.VersionLabel + " TEXT" +
+		", " + columns.Digest + " TEXT" +
+		", " + columns.Success + " BOOLEAN" +
+		", " + columns.DurationMs + " BIGINT" +
+		", " + columns.Algorithm + " TEXT" +
+		", " + columns.AppliedBy + " TEXT" +
+		", " + columns.Size + " BIGINT" +
+		", " + columns.NoOp + " BOOLEAN)"
+}
+
+func (dialect) TableExistsQuery(name string) (string, []interface{}) {
+	return `select exists(select 1 from sqlite_master where type = 'table' and name = $1)`, []interface{}{name}
+}
+
+func (dialect) ServerVersionQuery() string {
+	return "SELECT sqlite_version()"
+}
 
-	ds := &sqliteDataSource{
-		tablename:  cfg.TableNameOrDefault(),
-		basepath:   cfg.Basepath,
-		setFS:      cfg.FileSystem,
-		successful: false,
+func (dialect) Upgrades() []dsync.ColumnUpgrade {
+	return []dsync.ColumnUpgrade{
+		{Column: "VersionLabel", Definition: "TEXT"},
+		{Column: "Digest", Definition: "TEXT"},
+		{Column: "Success", Definition: "BOOLEAN"},
+		{Column: "DurationMs", Definition: "BIGINT"},
+		{Column: "Algorithm", Definition: "TEXT"},
+		{Column: "AppliedBy", Definition: "TEXT"},
+		{Column: "Size", Definition: "BIGINT"},
+		{Column: "NoOp", Definition: "BOOLEAN"},
 	}
+}
+
+// ColumnExists Reports whether the migration table has a column
+func (dialect) ColumnExists(ctx context.Context, b *dsync.SQLSource, column string) (bool, error) {
+	q := `select exists(select 1 from pragma_table_info($1) where lower(name) = lower($2))`
+	var exists bool
+	err := b.Writer().QueryRowContext(ctx, q, b.TableName(), column).Scan(&exists)
+	return exists, err
+}
+
+func (dialect) AddColumnStatement(table string, column string, definition string) string {
+	return "ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition
+}
+
+// ParseTimestamp see timestamp
+func (dialect) ParseTimestamp(value interface{}) (time.Time, error) {
+	var ts timestamp
+	err := ts.Scan(value)
+	return ts.Time, err
+}
+
+// FormatTimestamp see formatTimestamp
+func (dialect) FormatTimestamp(t time.Time) interface{} {
+	return formatTimestamp(t)
+}
 
-	if err = dsync.ValidateConfig(cfg); err != nil {
+// New Open a connection to the database identified by dsn and create a data source over it
+func New(dsn string, cfg *dsync.Config) (dsync.DataSource, error) {
+	if err := dsync.ValidateConfig(cfg); err != nil {
 		return nil, err
 	}
 
-	ds.db, err = sql.Open("sqlite3", dsn)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := ds.db.Ping(); err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	sb.WriteString(`CREATE TABLE "`)
-	sb.WriteString(ds.tablename)
-	sb.WriteString(`"`)
-	sb.WriteString(`(Id INTEGER PRIMARY KEY AUTOINCREMENT
-		, Name TEXT NOT NULL
-		, File TEXT NOT NULL
-		, Version INTEGER NOT NULL
-		, CreatedAt TIMESTAMP
-		, Checksum INTEGER NOT NULL)`,
-	)
-	ds.createTableQuery = sb.String()
-	sb.Reset()
-
-	sb.WriteString(`SELECT Id, Name, File, Version, CreatedAt, Checksum FROM "`)
-	sb.WriteString(ds.tablename)
-	sb.WriteString(`" ORDER BY Version ASC`)
-	ds.selectionQuery = sb.String()
-	sb.Reset()
-
-	sb.WriteString(`INSERT INTO "`)
-	sb.WriteString(ds.tablename)
-	sb.WriteString(`"`)
-	sb.WriteString(`(Name, File, Version, CreatedAt, Checksum) VALUES ($1, $2, $3, $4, $5)`)
-	ds.insertionQuery = sb.String()
-
+	ds, err := Wrap(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	ds.(*sqliteDataSource).Owned = true
 	return ds, nil
 }
 
-func (p *sqliteDataSource) BeginTransaction() error {
-	if p.tx != nil {
-		return errors.New("already in transaction")
+// Wrap Create a data source over an existing database handle
+func Wrap(db *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	src, err := dsync.NewSQLSource(db, dialect{}, cfg)
+	if err != nil {
+		return nil, err
 	}
-	tx, err := p.db.Begin()
+	return &sqliteDataSource{SQLSource: *src}, nil
+}
+
+// WrapRW Create a data source reading the migration table from readDB, e.g. a read replica, and writing it, as
+// well as applying migrations, through writeDB. See Wrap.
+func WrapRW(readDB *sql.DB, writeDB *sql.DB, cfg *dsync.Config) (dsync.DataSource, error) {
+	ds, err := Wrap(writeDB, cfg)
 	if err != nil {
+		return nil, err
+	}
+	ds.(*sqliteDataSource).Reads = readDB
+	return ds, nil
+}
+
+func (p *sqliteDataSource) BeginTransaction() error {
+	return p.BeginTransactionContext(context.Background())
+}
+
+func (p *sqliteDataSource) BeginTransactionContext(ctx context.Context) error {
+	if err := p.SQLSource.BeginTransactionContext(ctx); err != nil {
 		return err
 	}
-	p.tx = tx
+	if p.immediate {
+		// database/sql always issues a deferred BEGIN. Writing straight away takes the database's write
+		// lock up front, the same as BEGIN IMMEDIATE, so concurrent migrators wait here instead of failing
+		// halfway through with SQLITE_BUSY.
+		if _, err := p.Conn().ExecContext(ctx, `DELETE FROM `+p.Table()+` WHERE 0`); err != nil {
+			p.EndTransaction()
+			return err
+		}
+	}
 	return nil
 }
 
-func (p *sqliteDataSource) SetTransactionSuccessful(b bool) {
-	p.successful = b
+// Lock Start every following transaction with the database's write lock held. The lock is only acquired when a
+// transaction begins, see LocksTransactions.
+func (p *sqliteDataSource) Lock(ctx context.Context) error {
+	p.immediate = true
+	return nil
 }
 
-func (p sqliteDataSource) EndTransaction() {
-	if p.successful {
-		p.tx.Commit()
-	} else {
-		p.tx.Rollback()
-	}
+// LocksTransactions Reports whether Lock was called, see dsync.TransactionLocker
+func (p *sqliteDataSource) LocksTransactions() bool {
+	return p.immediate
 }
 
-func (p sqliteDataSource) GetChangeSetFileSystem() (fs.FS, error) {
-	return p.setFS, nil
+func (p *sqliteDataSource) Unlock() error {
+	p.immediate = false
+	return nil
 }
 
-func (p sqliteDataSource) GetMigrationInfo() (*dsync.MigrationInfo, error) {
-	// Connect
+// CheckIntegrity CheckIntegrityContext using context.Background()
+func (p sqliteDataSource) CheckIntegrity() error {
+	return p.CheckIntegrityContext(context.Background())
+}
 
-	q := `select exists(select 1 from sqlite_master where type = 'table' and name = $1)`
-	var currentVersion int64
-	var exists bool
-	if err := p.db.QueryRow(q, p.tablename).Scan(&exists); err != nil {
-		return nil, err
+// CheckIntegrityContext see dsync.IntegrityChecker
+func (p sqliteDataSource) CheckIntegrityContext(ctx context.Context) error {
+	var violations []dsync.IntegrityViolation
+
+	r, err := p.DB.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
-	if exists {
-		var migrations []dsync.Migration
-		r, err := p.db.Query(p.selectionQuery)
-		if err != nil {
-			return nil, err
+	for r.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int64
+		if err := r.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
 		}
-		for r.Next() {
-			var migration dsync.Migration
-			err := r.Scan(&migration.Id, &migration.Name, &migration.File, &migration.Version, &migration.CreatedAt, &migration.Checksum)
-			if err != nil {
-				return nil, err
-			}
-			migrations = append(migrations, migration)
+		violations = append(violations, dsync.IntegrityViolation{
+			Table:      table,
+			Constraint: "foreign key " + strconv.FormatInt(fkid, 10),
+			Detail:     "row " + strconv.FormatInt(rowid.Int64, 10) + " references missing row in " + parent,
+		})
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &dsync.IntegrityError{Violations: violations}
+	}
+	return nil
+}
+
+func (p sqliteDataSource) Schema() (*dsync.Schema, error) {
+	var schema dsync.Schema
+
+	r, err := p.DB.Query(`SELECT name, sql FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name <> $1
+		ORDER BY name`, p.TableName())
+	if err != nil {
+		return nil, err
+	}
+	for r.Next() {
+		var table dsync.Table
+		if err := r.Scan(&table.Name, &table.Definition); err != nil {
+			r.Close()
+			return nil, err
 		}
-		l := len(migrations)
-		if l > 0 {
-			currentVersion = migrations[l-1].Version
+		schema.Tables = append(schema.Tables, table)
+	}
+	r.Close()
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range schema.Tables {
+		table := &schema.Tables[i]
+		if table.Columns, err = p.columns(table.Name); err != nil {
+			return nil, err
 		}
-		return &dsync.MigrationInfo{TableName: p.tablename, Migrations: migrations, Version: currentVersion}, nil
-	} else {
-		_, err := p.db.Exec(p.createTableQuery)
-		if err != nil {
+		if table.Indexes, err = p.indexes(table.Name); err != nil {
 			return nil, err
 		}
-		return &dsync.MigrationInfo{
-			TableName: p.tablename,
-		}, nil
 	}
+	return &schema, nil
 }
 
-func (p sqliteDataSource) ApplyMigration(m *dsync.Migration) error {
-	var buf []byte
-	var sb strings.Builder
-	f, err := p.setFS.Open(filepath.Join(p.basepath, m.File))
-
-	m.Success = false
-	m.CreatedAt = time.Now()
+func (p sqliteDataSource) columns(table string) ([]dsync.Column, error) {
+	var columns []dsync.Column
 
+	r, err := p.DB.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info($1) ORDER BY cid`, table)
 	if err != nil {
-		return nil
-	}
-
-	defer f.Close()
-
-	buf = make([]byte, 1024)
-	for {
-		l, err := f.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				query := sb.String()
-				_, err := p.tx.Exec(query)
-				if err != nil {
-					return &dsync.MigrationError{Err: err, Migration: m}
-				}
-				m.Success = true
-				return p.logMigration(m)
-			} else {
-				return &dsync.MigrationError{Err: err, Migration: m}
-			}
-		} else {
-			sb.Write(buf[:l])
+		return nil, err
+	}
+	defer r.Close()
+
+	for r.Next() {
+		var column dsync.Column
+		var def sql.NullString
+		var pk int
+		if err := r.Scan(&column.Name, &column.Type, &column.NotNull, &def, &pk); err != nil {
+			return nil, err
 		}
+		column.Default, column.HasDefault = def.String, def.Valid
+		column.PrimaryKey = pk > 0
+		columns = append(columns, column)
 	}
+	return columns, r.Err()
 }
 
-func (p sqliteDataSource) GetPath() string {
-	return p.basepath
-}
+func (p sqliteDataSource) indexes(table string) ([]dsync.Index, error) {
+	var indexes []dsync.Index
 
-func (p sqliteDataSource) logMigration(m *dsync.Migration) error {
-	_, err := p.tx.Exec(p.insertionQuery, m.Name, m.File, m.Version, m.CreatedAt, m.Checksum)
+	// automatic indexes (primary keys, unique constraints) have no sql and are part of the table definition
+	r, err := p.DB.Query(`SELECT name, sql FROM sqlite_master
+		WHERE type = 'index' AND tbl_name = $1 AND sql IS NOT NULL
+		ORDER BY name`, table)
 	if err != nil {
-		return &dsync.MigrationError{Err: err, Migration: m}
+		return nil, err
 	}
-	return nil
+	defer r.Close()
+
+	for r.Next() {
+		var index dsync.Index
+		if err := r.Scan(&index.Name, &index.Definition); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, r.Err()
 }
 
-func (ds sqliteDataSource) Handle() *sql.DB {
-	return ds.db
+// IsTransient Busy or locked databases, held by another connection, as well as dsync.IsTransientError
+func (p sqliteDataSource) IsTransient(err error) bool {
+	var serr sqlite3.Error
+	if errors.As(err, &serr) {
+		return serr.Code == sqlite3.ErrBusy || serr.Code == sqlite3.ErrLocked
+	}
+	return dsync.IsTransientError(err)
 }
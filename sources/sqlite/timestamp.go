@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// timestampLayout The layout used to store CreatedAt. Timestamps are always written in UTC as text so that
+// every sqlite driver (mattn/go-sqlite3, modernc.org/sqlite, ...) reads back the same value, and the fixed
+// width keeps lexical and chronological ordering identical.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// layouts accepted when reading timestamps written by this package or directly by a driver
+var layouts = []string{
+	timestampLayout,
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",     // mattn/go-sqlite3
+	"2006-01-02 15:04:05.999999999 -0700 MST", // modernc.org/sqlite (time.Time.String)
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+// timestamp Scans a CreatedAt column regardless of how the driver decoded it
+type timestamp struct {
+	time.Time
+}
+
+func (ts *timestamp) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		ts.Time = time.Time{}
+		return nil
+	case time.Time:
+		ts.Time = v.UTC()
+		return nil
+	case int64:
+		ts.Time = time.Unix(v, 0).UTC()
+		return nil
+	case []byte:
+		return ts.parse(string(v))
+	case string:
+		return ts.parse(v)
+	}
+	return fmt.Errorf("unsupported timestamp type %T", value)
+}
+
+func (ts *timestamp) parse(s string) error {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			ts.Time = t.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized timestamp %q", s)
+}
@@ -0,0 +1,687 @@
+package dsync
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Execer Common interface of *sql.DB, *sql.Conn and *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Queryer Common interface of *sql.DB and *sql.Conn the migration table is read from
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SQLSource Data source over a database/sql handle, configured by a Dialect
+type SQLSource struct {
+	DB *sql.DB
+	// Owned The handle was opened by the data source, and is closed by Close
+	Owned bool
+	// Reads Handle the migration table is read from when set, e.g. a read replica
+	Reads *sql.DB
+
+	dialect          Dialect
+	tx               *sql.Tx
+	session          *sql.Conn
+	sessionSetup     []string
+	basepath         string
+	basepaths        []string
+	extensions       []string
+	successful       bool
+	setFS            fs.FS
+	tablename        string
+	columns          MigrationColumns
+	multiStatement   bool
+	createTableQuery string
+	selectionQuery   string
+	insertionQuery   string
+	deletionQuery    string
+	updateQuery      string
+	completionQuery  string
+	abortQuery       string
+}
+
+// NewSource Create a data source over db keeping the migration table with dialect, e.g. for a database without a
+// bundled source. The handle is left open for its owner to close.
+func NewSource(db *sql.DB, dialect Dialect, cfg *Config) (DataSource, error) {
+	return NewSQLSource(db, dialect, cfg)
+}
+
+// NewSQLSource NewSource returning the concrete data source, for sources embedding it to add database specific
+// capabilities (locks, integrity checks...)
+func NewSQLSource(db *sql.DB, dialect Dialect, cfg *Config) (*SQLSource, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+	if db == nil || dialect == nil {
+		return nil, errors.New("missing database handle or dialect")
+	}
+	if _, ok := dialect.(TableQuoter); !ok && strings.Contains(cfg.TableNameOrDefault(), ".") {
+		return nil, errors.Errorf("invalid table name %q: the database does not support schema qualified table names", cfg.TableNameOrDefault())
+	}
+
+	b := SQLSource{
+		DB:             db,
+		dialect:        dialect,
+		tablename:      cfg.TableNameOrDefault(),
+		columns:        cfg.ColumnsOrDefault(),
+		basepath:       cfg.BasepathOrDefault(),
+		basepaths:      cfg.Basepaths,
+		extensions:     cfg.Extensions,
+		setFS:          cfg.FileSystem,
+		multiStatement: cfg.MultiStatement,
+		sessionSetup:   cfg.SessionSetup,
+	}
+
+	columns := b.quotedColumns()
+	p := dialect.Placeholder
+	table := b.Table()
+
+	b.createTableQuery = dialect.CreateTableStatement(table, columns)
+	if len(strings.TrimSpace(cfg.CreateTableStatement)) > 0 {
+		b.createTableQuery = cfg.CreateTableStatement
+	}
+
+	b.selectionQuery = "SELECT " + strings.Join(columns.Names(), ", ") + " FROM " + table +
+		" ORDER BY " + columns.Version + " ASC, " + columns.Id + " ASC"
+
+	var values []string
+	for i := range columns.Names()[1:] {
+		values = append(values, p(i+1))
+	}
+	b.insertionQuery = "INSERT INTO " + table + "(" + strings.Join(columns.Names()[1:], ", ") + ") VALUES (" + strings.Join(values, ", ") + ")"
+
+	b.deletionQuery = "DELETE FROM " + table + " WHERE " + columns.Id + " = " + p(1)
+
+	b.updateQuery = "UPDATE " + table + " SET " + columns.Checksum + " = " + p(1) + ", " + columns.Digest + " = " + p(2) +
+		", " + columns.Algorithm + " = " + p(3) + ", " + columns.Size + " = " + p(4) + " WHERE " + columns.Id + " = " + p(5)
+
+	b.completionQuery = "UPDATE " + table + " SET " + columns.Success + " = " + p(1) + ", " + columns.DurationMs + " = " + p(2) +
+		" WHERE " + columns.File + " = " + p(3) + " AND " + columns.Success + " = " + p(4)
+
+	b.abortQuery = "DELETE FROM " + table + " WHERE " + columns.File + " = " + p(1) + " AND " + columns.Success + " = " + p(2)
+
+	return &b, nil
+}
+
+// quotedColumns Names of the columns of the migration table as used in queries, quoted for a ColumnQuoter
+func (b SQLSource) quotedColumns() MigrationColumns {
+	if quoter, ok := b.dialect.(ColumnQuoter); ok && quoter.QuoteColumns() {
+		return b.columns.Quote(b.dialect.QuoteIdentifier)
+	}
+	return b.columns
+}
+
+// Dialect Returns the dialect of the data source, e.g. for NewSQLAuditSink
+func (b SQLSource) Dialect() Dialect {
+	return b.dialect
+}
+
+// Table Quoted name of the migration table
+func (b SQLSource) Table() string {
+	return b.QuoteTable(b.tablename)
+}
+
+// QuoteTable Quoted name of a table of the data source, e.g. a lock table, see TableQuoter
+func (b SQLSource) QuoteTable(name string) string {
+	if quoter, ok := b.dialect.(TableQuoter); ok {
+		return quoter.QuoteTable(name)
+	}
+	return b.dialect.QuoteIdentifier(name)
+}
+
+// Columns Names of the columns of the migration table, unquoted
+func (b SQLSource) Columns() MigrationColumns {
+	return b.columns
+}
+
+// Reader Returns the handle the migration table is read from: the session set up with Config.SessionSetup if
+// any, otherwise Reads if set
+func (b SQLSource) Reader() Queryer {
+	if b.session != nil {
+		return b.session
+	}
+	if b.Reads != nil {
+		return b.Reads
+	}
+	return b.DB
+}
+
+// Conn Returns the active transaction, or the database handle for migrations applied outside of a transaction
+func (b SQLSource) Conn() Execer {
+	if b.tx != nil {
+		return b.tx
+	}
+	return b.Writer()
+}
+
+// Writer Returns the handle the migration table is created and altered with outside of a transaction: the session
+// set up with Config.SessionSetup if any, the database handle otherwise
+func (b SQLSource) Writer() Execer {
+	if b.session != nil {
+		return b.session
+	}
+	return b.DB
+}
+
+// withSession Returns a copy of the data source working on a dedicated connection set up with Config.SessionSetup,
+// and the function closing it. The data source is returned as is within a transaction, which is set up on its own,
+// or without session setup.
+func (b SQLSource) withSession(ctx context.Context) (SQLSource, func(), error) {
+	if b.tx != nil || b.session != nil || len(b.sessionSetup) == 0 {
+		return b, func() {}, nil
+	}
+	conn, err := OpenSession(ctx, b.DB, b.sessionSetup)
+	if err != nil {
+		return b, nil, err
+	}
+	b.session = conn
+	return b, func() { conn.Close() }, nil
+}
+
+func (b *SQLSource) BeginTransaction() error {
+	return b.BeginTransactionContext(context.Background())
+}
+
+func (b *SQLSource) BeginTransactionContext(ctx context.Context) error {
+	if b.tx != nil {
+		return errors.New("already in transaction")
+	}
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := RunSessionSetup(ctx, tx, b.sessionSetup); err != nil {
+		tx.Rollback()
+		return err
+	}
+	b.tx = tx
+	return nil
+}
+
+func (b *SQLSource) SetTransactionSuccessful(successful bool) {
+	b.successful = successful
+}
+
+func (b *SQLSource) EndTransaction() error {
+	if b.tx == nil {
+		// BeginTransaction failed or was not called
+		b.successful = false
+		return nil
+	}
+	var err error
+	if b.successful {
+		err = b.tx.Commit()
+	} else {
+		err = b.tx.Rollback()
+	}
+	b.tx = nil
+	b.successful = false
+	return err
+}
+
+func (b SQLSource) GetChangeSetFileSystem() (fs.FS, error) {
+	return b.setFS, nil
+}
+
+func (b SQLSource) GetMigrationInfo() (*MigrationInfo, error) {
+	return b.GetMigrationInfoContext(context.Background())
+}
+
+func (b SQLSource) GetMigrationInfoContext(ctx context.Context) (*MigrationInfo, error) {
+	b, closeSession, err := b.withSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSession()
+
+	exists, err := b.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if _, err := b.Writer().ExecContext(ctx, b.createTableQuery); err != nil {
+			return nil, err
+		}
+		return &MigrationInfo{TableName: b.tablename}, nil
+	}
+
+	if err := b.upgradeTable(ctx); err != nil {
+		return nil, err
+	}
+	return b.queryMigrations(ctx, b.selectionQuery)
+}
+
+// ReadMigrationInfo Read the migration table without creating or upgrading it, see MigrationInfoReader. Nothing is
+// applied without the table, and the columns it does not have yet are read as NULL.
+func (b SQLSource) ReadMigrationInfo(ctx context.Context) (*MigrationInfo, error) {
+	b, closeSession, err := b.withSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSession()
+
+	exists, err := b.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &MigrationInfo{TableName: b.tablename}, nil
+	}
+
+	columns := b.quotedColumns()
+	names := columns.Names()
+	if upgrader, ok := b.dialect.(ColumnUpgrader); ok {
+		for _, upgrade := range upgrader.Upgrades() {
+			exists, err := upgrader.ColumnExists(ctx, &b, b.columns.Column(upgrade.Column))
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				continue
+			}
+			for i, column := range MigrationTableColumns {
+				if column == upgrade.Column {
+					names[i] = "NULL"
+				}
+			}
+		}
+	}
+	return b.queryMigrations(ctx, "SELECT "+strings.Join(names, ", ")+" FROM "+b.Table()+
+		" ORDER BY "+columns.Version+" ASC, "+columns.Id+" ASC")
+}
+
+// queryMigrations Read the rows of the migration table selected by query, in the column order of selectionQuery
+func (b SQLSource) queryMigrations(ctx context.Context, query string) (*MigrationInfo, error) {
+	r, err := b.Reader().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var migrations []Migration
+	var currentVersion int64
+	for r.Next() {
+		var migration Migration
+		var createdAt interface{} = &migration.CreatedAt
+		var versionLabel, digest sql.NullString
+		var success, noOp sql.NullBool
+		var durationMs, size sql.NullInt64
+		var algorithm, appliedBy sql.NullString
+		if codec, ok := b.dialect.(TimestampCodec); ok {
+			createdAt = &timestamp{parse: codec.ParseTimestamp}
+		}
+		err := r.Scan(&migration.Id, &migration.Name, &migration.File, &migration.Version, createdAt, &migration.Checksum, &versionLabel, &digest, &success, &durationMs, &algorithm, &appliedBy, &size, &noOp)
+		if err != nil {
+			return nil, err
+		}
+		if ts, ok := createdAt.(*timestamp); ok {
+			migration.CreatedAt = ts.Time
+		}
+		migration.VersionLabel = versionLabel.String
+		migration.Digest = digest.String
+		// rows recorded before the Success column existed were complete
+		migration.Success = !success.Valid || success.Bool
+		migration.Duration = time.Duration(durationMs.Int64) * time.Millisecond
+		migration.AppliedBy = appliedBy.String
+		migration.Size = size.Int64
+		migration.NoOp = noOp.Bool
+		if migration.Algorithm, err = ParseChecksumAlgorithm(algorithm.String); err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if l := len(migrations); l > 0 {
+		currentVersion = migrations[l-1].Version
+	}
+	return &MigrationInfo{TableName: b.tablename, Migrations: migrations, Version: currentVersion}, nil
+}
+
+// timestamp Scans a CreatedAt column with TimestampCodec.ParseTimestamp
+type timestamp struct {
+	time.Time
+	parse func(value interface{}) (time.Time, error)
+}
+
+func (ts *timestamp) Scan(value interface{}) (err error) {
+	ts.Time, err = ts.parse(value)
+	return err
+}
+
+// upgradeTable Add the ColumnUpgrader columns missing from the migration table
+func (b SQLSource) upgradeTable(ctx context.Context) error {
+	upgrader, ok := b.dialect.(ColumnUpgrader)
+	if !ok {
+		return nil
+	}
+	for _, upgrade := range upgrader.Upgrades() {
+		column := b.columns.Column(upgrade.Column)
+		exists, err := upgrader.ColumnExists(ctx, &b, column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if quoter, ok := b.dialect.(ColumnQuoter); ok && quoter.QuoteColumns() {
+			column = b.dialect.QuoteIdentifier(column)
+		}
+		if _, err := b.Writer().ExecContext(ctx, upgrader.AddColumnStatement(b.Table(), column, upgrade.Definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b SQLSource) ApplyMigration(m *Migration) error {
+	return b.ApplyMigrationContext(context.Background(), m)
+}
+
+func (b SQLSource) ApplyMigrationContext(ctx context.Context, m *Migration) error {
+	if m.NoTransaction {
+		var closeSession func()
+		var err error
+		if b, closeSession, err = b.withSession(ctx); err != nil {
+			return &MigrationError{Err: err, Migration: m}
+		}
+		defer closeSession()
+	}
+	f, err := OpenMigrationFile(b.setFS, filepath.Join(b.basepath, m.File))
+
+	m.Success = false
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+
+	if err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+
+	defer f.Close()
+
+	script, err := RenderTemplate(m.File, UpSection(f), m.TemplateData)
+	if err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+
+	if m.NoTransaction {
+		// recorded as incomplete first, so that an interruption after the script ran is detected. The record of a
+		// changed repeatable migration is only replaced once the script succeeded.
+		if err := b.insertMigration(ctx, m); err != nil {
+			return err
+		}
+	}
+	started := time.Now()
+	err = b.exec(ctx, script, m.Placeholders)
+	if err == nil {
+		err = b.insertBlobs(ctx, m)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// cancelled, the caller rolls back the transaction
+			err = ctx.Err()
+		}
+		if m.NoTransaction {
+			b.Conn().ExecContext(context.Background(), b.abortQuery, m.File, false)
+		}
+		return &MigrationError{Err: err, Migration: m}
+	}
+	m.Success = true
+	m.Duration = time.Since(started)
+	if m.NoTransaction {
+		return b.completeMigration(ctx, m)
+	}
+	return b.logMigration(ctx, m)
+}
+
+// exec Execute a migration script one statement at a time as it is read, or as a whole when the driver supports
+// multiple statements per Exec. Placeholders are expanded in each statement.
+func (b SQLSource) exec(ctx context.Context, script io.Reader, placeholders map[string]string) error {
+	if b.multiStatement {
+		content, err := io.ReadAll(script)
+		if err != nil {
+			return err
+		}
+		statement, err := ExpandPlaceholders(string(content), placeholders)
+		if err != nil {
+			return err
+		}
+		_, err = b.Conn().ExecContext(ctx, statement)
+		return err
+	}
+
+	scanner := NewStatementScanner(script)
+	for {
+		statement, err := scanner.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if statement, err = ExpandPlaceholders(statement, placeholders); err != nil {
+			return err
+		}
+		if _, err := b.Conn().ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+}
+
+// insertBlobs Insert the data files declared by the blob directives of a migration
+func (b SQLSource) insertBlobs(ctx context.Context, m *Migration) error {
+	for _, blob := range m.Blobs {
+		data, err := ReadBlob(b.setFS, b.basepath, m, blob)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(blob.Table, ".")
+		for i := range parts {
+			parts[i] = b.dialect.QuoteIdentifier(parts[i])
+		}
+		query := "INSERT INTO " + strings.Join(parts, ".") + " (" + b.dialect.QuoteIdentifier(blob.Column) + ") VALUES (" + b.dialect.Placeholder(1) + ")"
+		if _, err := b.Conn().ExecContext(ctx, query, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvaluateCondition Run the query of a "-- dsync:when" directive, which returns a boolean, or a BIT or 0/1 integer
+// in dialects without booleans
+func (b SQLSource) EvaluateCondition(query string) (bool, error) {
+	return b.EvaluateConditionContext(context.Background(), query)
+}
+
+// EvaluateConditionContext EvaluateCondition using the given context, see ConditionEvaluator
+func (b SQLSource) EvaluateConditionContext(ctx context.Context, query string) (bool, error) {
+	var ok bool
+	err := b.Conn().QueryRowContext(ctx, query).Scan(&ok)
+	return ok, err
+}
+
+func (b SQLSource) RevertMigration(m *Migration, script string) error {
+	return b.RevertMigrationContext(context.Background(), m, script)
+}
+
+// RevertMigrationContext RevertMigration using the given context, see Reverter
+func (b SQLSource) RevertMigrationContext(ctx context.Context, m *Migration, script string) error {
+	if err := b.exec(ctx, strings.NewReader(script), m.Placeholders); err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+	if _, err := b.Conn().ExecContext(ctx, b.deletionQuery, m.Id); err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+	return nil
+}
+
+func (b SQLSource) UpdateChecksum(m *Migration) error {
+	return b.UpdateChecksumContext(context.Background(), m)
+}
+
+// UpdateChecksumContext UpdateChecksum using the given context, see ChecksumRepairer
+func (b SQLSource) UpdateChecksumContext(ctx context.Context, m *Migration) error {
+	if _, err := b.Conn().ExecContext(ctx, b.updateQuery, m.Checksum, m.Digest, m.Algorithm.String(), m.Size, m.Id); err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+	return nil
+}
+
+// RecordMigration Record the migration as successfully applied without executing its script
+func (b SQLSource) RecordMigration(m *Migration) error {
+	return b.RecordMigrationContext(context.Background(), m)
+}
+
+// RecordMigrationContext RecordMigration using the given context, see MigrationRecorder
+func (b SQLSource) RecordMigrationContext(ctx context.Context, m *Migration) error {
+	m.Success = true
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	return b.logMigration(ctx, m)
+}
+
+// TableName Returns the name of the migration table, see TableInspector
+func (b SQLSource) TableName() string {
+	return b.tablename
+}
+
+// TableExists Reports whether the migration table exists without creating it, see TableInspector
+func (b SQLSource) TableExists() (bool, error) {
+	ctx := context.Background()
+	b, closeSession, err := b.withSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer closeSession()
+	return b.tableExists(ctx)
+}
+
+// tableExists Look the migration table up with the dialect
+func (b SQLSource) tableExists(ctx context.Context) (bool, error) {
+	if checker, ok := b.dialect.(TableExistsChecker); ok {
+		return checker.TableExists(ctx, &b)
+	}
+	return b.hasTable(ctx, b.tablename)
+}
+
+// hasTable Look a table up with the TableExistsQuery of the dialect
+func (b SQLSource) hasTable(ctx context.Context, name string) (bool, error) {
+	query, args := b.dialect.TableExistsQuery(name)
+	var exists bool
+	if err := b.Reader().QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// HasTable Reports whether a table exists with the TableExistsQuery of the dialect, see SchemaTableChecker
+func (b SQLSource) HasTable(name string) (bool, error) {
+	return b.HasTableContext(context.Background(), name)
+}
+
+// HasTableContext HasTable using the given context, see SchemaTableChecker
+func (b SQLSource) HasTableContext(ctx context.Context, name string) (bool, error) {
+	return b.hasTable(ctx, name)
+}
+
+// ServerVersion Returns the version of the server with the ServerVersionQuerier query of the dialect, see
+// ServerVersioner
+func (b SQLSource) ServerVersion() (string, error) {
+	return b.ServerVersionContext(context.Background())
+}
+
+// ServerVersionContext ServerVersion using the given context, see ServerVersioner
+func (b SQLSource) ServerVersionContext(ctx context.Context) (string, error) {
+	querier, ok := b.dialect.(ServerVersionQuerier)
+	if !ok {
+		return "", errors.New("dialect has no server version query")
+	}
+	var version string
+	err := b.Conn().QueryRowContext(ctx, querier.ServerVersionQuery()).Scan(&version)
+	return version, err
+}
+
+// CreateTableStatement Returns the statement creating the migration table
+func (b SQLSource) CreateTableStatement() string {
+	return b.createTableQuery
+}
+
+func (b SQLSource) GetPath() string {
+	return b.basepath
+}
+
+func (b SQLSource) GetPaths() []string {
+	return b.basepaths
+}
+
+func (b SQLSource) GetExtensions() []string {
+	return b.extensions
+}
+
+// completeMigration Mark the incomplete record of a migration applied outside of a transaction as successful
+func (b SQLSource) completeMigration(ctx context.Context, m *Migration) error {
+	if _, err := b.Conn().ExecContext(ctx, b.completionQuery, true, m.Duration.Milliseconds(), m.File, false); err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+	return b.deletePrevious(ctx, m)
+}
+
+func (b SQLSource) logMigration(ctx context.Context, m *Migration) error {
+	if err := b.deletePrevious(ctx, m); err != nil {
+		return err
+	}
+	return b.insertMigration(ctx, m)
+}
+
+// deletePrevious Delete the record a changed repeatable migration replaces, if any
+func (b SQLSource) deletePrevious(ctx context.Context, m *Migration) error {
+	if m.Id == 0 {
+		return nil
+	}
+	if _, err := b.Conn().ExecContext(ctx, b.deletionQuery, m.Id); err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+	return nil
+}
+
+// insertMigration Insert the record of a migration
+func (b SQLSource) insertMigration(ctx context.Context, m *Migration) error {
+	var createdAt interface{} = m.CreatedAt
+	if codec, ok := b.dialect.(TimestampCodec); ok {
+		createdAt = codec.FormatTimestamp(m.CreatedAt)
+	}
+	_, err := b.Conn().ExecContext(ctx, b.insertionQuery, m.Name, m.File, m.Version, createdAt, m.Checksum, m.VersionLabel, m.Digest, m.Success, m.Duration.Milliseconds(), m.Algorithm.String(), m.AppliedBy, m.Size, m.NoOp)
+	if err != nil {
+		return &MigrationError{Err: err, Migration: m}
+	}
+	return nil
+}
+
+func (b SQLSource) Handle() *sql.DB {
+	return b.DB
+}
+
+// Close Close the database handle when it was opened by the data source. Handles passed to Wrap are left open for
+// their owner to close.
+func (b SQLSource) Close() error {
+	if !b.Owned {
+		return nil
+	}
+	return b.DB.Close()
+}
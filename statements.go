@@ -0,0 +1,328 @@
+package dsync
+
+import (
+	"io"
+	"strings"
+)
+
+// SplitStatements Split a SQL script into its individual statements on top level semicolons. Semicolons within
+// string literals, quoted identifiers, dollar quoted bodies ($$ ... $$ or $tag$ ... $tag$) and comments do not end
+// a statement, nor do the ones within the BEGIN ... END body of a CREATE TRIGGER, PROCEDURE, FUNCTION or EVENT.
+//
+// As in the mysql client, a "DELIMITER <delimiter>" line (e.g. DELIMITER $$) makes the following statements end with
+// that delimiter instead of semicolons, until "DELIMITER ;". The directive lines are not statements.
+//
+// Statements are returned trimmed and without their terminating semicolon. Statements made of comments only are
+// dropped. Backslashes only escape quotes in E'...' strings; write quotes as ” in MySQL scripts.
+func SplitStatements(sql string) []string {
+	statements, _, _ := scanStatements(sql, true, ";")
+	return statements
+}
+
+// scanStatements Split sql into statements ending with delimiter, or a delimiter set by a DELIMITER directive.
+// Unless final, the trailing statement is not returned since it may be incomplete, rest being its offset in sql and
+// restDelimiter the delimiter in effect there.
+func scanStatements(sql string, final bool, delimiter string) (statements []string, rest int, restDelimiter string) {
+	var s splitter
+
+	start := 0
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		if !s.code && len(s.word) == 0 && isDelimiterDirective(sql[i:]) {
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 && !final {
+				break
+			}
+			if end < 0 {
+				end = len(sql) - i
+			}
+			if d := strings.TrimSpace(sql[i+len("DELIMITER") : i+end]); d != "" {
+				delimiter = d
+			}
+			i += end
+			start = i
+			continue
+		}
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+			s.endWord()
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+			s.endWord()
+		case c == '\'' || c == '"' || c == '`':
+			escapes := c == '\'' && strings.EqualFold(s.word, "E")
+			s.endWord()
+			s.code = true
+			i = skipQuoted(sql, i, escapes)
+		case delimiter != ";" && strings.HasPrefix(sql[i:], delimiter):
+			s.endWord()
+			if s.code {
+				statements = append(statements, strings.TrimSpace(sql[start:i]))
+			}
+			i += len(delimiter)
+			start = i
+			s = splitter{}
+		case c == '$' && len(s.word) == 0 && dollarTag(sql[i:]) != "":
+			s.code = true
+			i = skipDollarQuoted(sql, i)
+		case c == ';' && delimiter == ";":
+			s.endWord()
+			i++
+			if s.depth > 0 {
+				continue
+			}
+			if s.code {
+				statements = append(statements, strings.TrimSpace(sql[start:i-1]))
+			}
+			start = i
+			s = splitter{}
+		case isWordChar(c) || (c == '$' && len(s.word) > 0):
+			s.word += string(c)
+			s.code = true
+			i++
+		default:
+			s.endWord()
+			if !isSpace(c) {
+				s.code = true
+			}
+			i++
+		}
+	}
+	if !final {
+		return statements, start, delimiter
+	}
+	s.endWord()
+	if s.code {
+		statements = append(statements, strings.TrimSpace(sql[start:]))
+	}
+	return statements, len(sql), delimiter
+}
+
+// isDelimiterDirective Reports whether sql starts with a DELIMITER directive, matched ignoring case
+func isDelimiterDirective(sql string) bool {
+	const directive = "DELIMITER"
+	return len(sql) > len(directive) && strings.EqualFold(sql[:len(directive)], directive) &&
+		(sql[len(directive)] == ' ' || sql[len(directive)] == '\t')
+}
+
+// ScannerChunkSize Number of bytes read at once by a StatementScanner, i.e. by the data sources applying a script.
+// Read when a scanner first fills its buffer.
+var ScannerChunkSize = 64 * 1024
+
+// StatementScanner Read the statements of a script one at a time, splitting them like SplitStatements, without
+// holding more than the statement being read in memory
+type StatementScanner struct {
+	r       io.Reader
+	buf     []byte
+	chunk   []byte
+	pending []string
+	// delimiter Statement delimiter in effect at the start of buf
+	delimiter string
+	// scanned Size of the buffer when it was last scanned without completing a statement
+	scanned int
+	eof     bool
+}
+
+func NewStatementScanner(r io.Reader) *StatementScanner {
+	return &StatementScanner{r: r, delimiter: ";"}
+}
+
+// Next Returns the next statement of the script, io.EOF once every statement has been returned
+func (s *StatementScanner) Next() (string, error) {
+	for len(s.pending) == 0 {
+		if s.eof {
+			return "", io.EOF
+		}
+		if err := s.fill(); err != nil {
+			return "", err
+		}
+		statements, rest, delimiter := scanStatements(string(s.buf), s.eof, s.delimiter)
+		s.delimiter = delimiter
+		s.pending = statements
+		s.buf = append([]byte(nil), s.buf[rest:]...)
+		if len(statements) == 0 {
+			s.scanned = len(s.buf)
+		} else {
+			s.scanned = 0
+		}
+	}
+	statement := s.pending[0]
+	s.pending = s.pending[1:]
+	return statement, nil
+}
+
+// fill Read the next chunk of the script. A statement that spans many chunks is only scanned again once the buffer
+// has doubled, so that it is not scanned over and over.
+func (s *StatementScanner) fill() error {
+	if s.chunk == nil {
+		s.chunk = make([]byte, ScannerChunkSize)
+	}
+	target := len(s.buf) + len(s.chunk)
+	if 2*s.scanned > target {
+		target = 2 * s.scanned
+	}
+	for len(s.buf) < target {
+		n, err := s.r.Read(s.chunk)
+		s.buf = append(s.buf, s.chunk[:n]...)
+		if err == io.EOF {
+			s.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitter State of the statement being scanned by SplitStatements
+type splitter struct {
+	// code The statement contains something other than whitespace and comments
+	code bool
+	// word Word being scanned
+	word string
+	// prev Previous word, upper cased
+	prev string
+	// header Number of words of the statement so far, while they may still be the header of a compound
+	// statement (CREATE OR REPLACE TRIGGER ...). -1 once ruled out.
+	header int
+	// compound The statement creates a trigger, procedure, function or event, whose BEGIN ... END body may
+	// contain semicolons
+	compound bool
+	// depth Number of open BEGIN and CASE blocks
+	depth int
+}
+
+// headerWords Words that may precede TRIGGER, PROCEDURE, FUNCTION or EVENT in a CREATE statement
+var headerWords = map[string]bool{
+	"OR":           true,
+	"ALTER":        true,
+	"REPLACE":      true,
+	"TEMP":         true,
+	"TEMPORARY":    true,
+	"CONSTRAINT":   true,
+	"AGGREGATE":    true,
+	"DEFINER":      true,
+	"CURRENT_USER": true,
+}
+
+func (s *splitter) endWord() {
+	if len(s.word) == 0 {
+		return
+	}
+	word := strings.ToUpper(s.word)
+	s.word = ""
+
+	if s.header >= 0 {
+		switch {
+		case s.header == 0 && word == "CREATE", s.header > 0 && headerWords[word]:
+			s.header++
+		case s.header > 0 && (word == "TRIGGER" || word == "PROCEDURE" || word == "FUNCTION" || word == "EVENT"):
+			s.compound = true
+			s.header = -1
+		default:
+			s.header = -1
+		}
+	}
+
+	switch word {
+	case "BEGIN":
+		if s.compound {
+			s.depth++
+		}
+	case "CASE":
+		// END CASE closes a block rather than opening one
+		if s.prev != "END" {
+			s.depth++
+		}
+	case "END":
+		if s.depth > 0 {
+			s.depth--
+		}
+	case "IF", "LOOP", "WHILE", "REPEAT":
+		// END IF, END LOOP, ... close blocks that were not counted when opened
+		if s.prev == "END" {
+			s.depth++
+		}
+	}
+	s.prev = word
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func skipLineComment(sql string, i int) int {
+	if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(sql)
+}
+
+// skipBlockComment Skip a, possibly nested, /* */ comment
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// skipQuoted Skip a quoted string or identifier starting at i. A doubled quote character stands for itself.
+func skipQuoted(sql string, i int, backslashEscapes bool) int {
+	quote := sql[i]
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag Returns the $tag$ opening a dollar quoted string at the start of sql, if any
+func dollarTag(sql string) string {
+	for i := 1; i < len(sql); i++ {
+		c := sql[i]
+		if c == '$' {
+			return sql[:i+1]
+		}
+		if !isWordChar(c) || (i == 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func skipDollarQuoted(sql string, i int) int {
+	tag := dollarTag(sql[i:])
+	if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+		return i + len(tag) + end + len(tag)
+	}
+	return len(sql)
+}
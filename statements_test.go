@@ -0,0 +1,127 @@
+package dsync_test
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/SharkFourSix/dsync"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		script     string
+		statements []string
+	}{
+		{`CREATE TABLE a (id INT); CREATE TABLE b (id INT);`, []string{`CREATE TABLE a (id INT)`, `CREATE TABLE b (id INT)`}},
+		{`INSERT INTO a VALUES (1)`, []string{`INSERT INTO a VALUES (1)`}},
+		{"  ;\n-- only a comment;\n/* another; */ ;", nil},
+		{`INSERT INTO a VALUES ('x;y', 'it''s;');SELECT 1`, []string{`INSERT INTO a VALUES ('x;y', 'it''s;')`, `SELECT 1`}},
+		{`SELECT E'it\'s;'; SELECT 2`, []string{`SELECT E'it\'s;'`, `SELECT 2`}},
+		{`CREATE TABLE "a;b" (id INT); CREATE TABLE ` + "`c;d`" + ` (id INT)`, []string{`CREATE TABLE "a;b" (id INT)`, "CREATE TABLE `c;d` (id INT)"}},
+		{"-- header; comment\nSELECT 1; /* a /* nested; */ comment; */ SELECT 2;", []string{"-- header; comment\nSELECT 1", "/* a /* nested; */ comment; */ SELECT 2"}},
+		{
+			`CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql; SELECT $1::int;`,
+			[]string{`CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql`, `SELECT $1::int`},
+		},
+		{
+			`DO $body$ BEGIN PERFORM 1; END $body$; SELECT 1`,
+			[]string{`DO $body$ BEGIN PERFORM 1; END $body$`, `SELECT 1`},
+		},
+		{
+			`CREATE TRIGGER t AFTER INSERT ON a BEGIN INSERT INTO b VALUES (CASE WHEN 1 THEN 1 END); DELETE FROM c; END; SELECT 1;`,
+			[]string{`CREATE TRIGGER t AFTER INSERT ON a BEGIN INSERT INTO b VALUES (CASE WHEN 1 THEN 1 END); DELETE FROM c; END`, `SELECT 1`},
+		},
+		{
+			`CREATE PROCEDURE p() BEGIN IF 1 THEN SELECT 1; END IF; CASE WHEN 1 THEN SELECT 2; END CASE; END; SELECT 3`,
+			[]string{`CREATE PROCEDURE p() BEGIN IF 1 THEN SELECT 1; END IF; CASE WHEN 1 THEN SELECT 2; END CASE; END`, `SELECT 3`},
+		},
+		{
+			`CREATE OR ALTER PROCEDURE p AS BEGIN BEGIN TRY SELECT 1; END TRY BEGIN CATCH SELECT 2; END CATCH; END; SELECT 3`,
+			[]string{`CREATE OR ALTER PROCEDURE p AS BEGIN BEGIN TRY SELECT 1; END TRY BEGIN CATCH SELECT 2; END CATCH; END`, `SELECT 3`},
+		},
+		{`BEGIN; CREATE TABLE a (begin_at INT); END;`, []string{`BEGIN`, `CREATE TABLE a (begin_at INT)`, `END`}},
+		{
+			"DELIMITER $$\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT ';'; END $$\nDELIMITER ;\nSELECT 2;",
+			[]string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT ';'; END", `SELECT 2`},
+		},
+		{
+			"delimiter //\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END//\nSELECT 1//",
+			[]string{"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END", `SELECT 1`},
+		},
+		{"SELECT delimiter FROM t; SELECT 2", []string{`SELECT delimiter FROM t`, `SELECT 2`}},
+		{
+			"CREATE PROCEDURE p AS SET NOCOUNT ON; SELECT 1; SELECT 2;",
+			[]string{`CREATE PROCEDURE p AS SET NOCOUNT ON`, `SELECT 1`, `SELECT 2`},
+		},
+		{
+			"CREATE PROCEDURE p AS BEGIN SET NOCOUNT ON; IF @x = 1 BEGIN SELECT 1; END ELSE SELECT 2; UPDATE a SET b = 1; END; SELECT 3",
+			[]string{"CREATE PROCEDURE p AS BEGIN SET NOCOUNT ON; IF @x = 1 BEGIN SELECT 1; END ELSE SELECT 2; UPDATE a SET b = 1; END", `SELECT 3`},
+		},
+		{
+			"DELIMITER $$\nCREATE PROCEDURE p AS\n SET NOCOUNT ON;\n SELECT 1;\n DELETE FROM a;\n$$\nDELIMITER ;\nSELECT 3;",
+			[]string{"CREATE PROCEDURE p AS\n SET NOCOUNT ON;\n SELECT 1;\n DELETE FROM a;", `SELECT 3`},
+		},
+	}
+
+	for _, test := range tests {
+		statements := dsync.SplitStatements(test.script)
+		if !reflect.DeepEqual(statements, test.statements) {
+			t.Errorf("%q: expected %q, got %q", test.script, test.statements, statements)
+		}
+	}
+}
+
+func TestStatementScanner(t *testing.T) {
+	var script strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&script, "INSERT INTO a VALUES (%d, 'row;%d');\n", i, i)
+	}
+	// a statement spanning many reads, with a dollar quoted body and a comment
+	script.WriteString("CREATE FUNCTION f() RETURNS TEXT AS $body$ SELECT '" + strings.Repeat("x;", 100000) + "' $body$ LANGUAGE sql;\n")
+	// a delimiter directive spanning reads, and a procedure body ending with it
+	script.WriteString("DELIMITER $$\nCREATE PROCEDURE p() BEGIN " + strings.Repeat("SELECT 1; ", 10000) + "END $$\nDELIMITER ;\n")
+	script.WriteString("-- trailing comment;\nSELECT 1")
+
+	expected := dsync.SplitStatements(script.String())
+
+	var statements []string
+	scanner := dsync.NewStatementScanner(iotest.HalfReader(strings.NewReader(script.String())))
+	for {
+		statement, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		statements = append(statements, statement)
+	}
+	if len(statements) != 5003 || !reflect.DeepEqual(statements, expected) {
+		t.Fatalf("expected the statements of SplitStatements, got %d statements", len(statements))
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		script     string
+		normalized string
+	}{
+		{"CREATE TABLE a (id INT);", "CREATE TABLE a(id INT);"},
+		{"SELECT a , b FROM t ; SELECT 1", "SELECT a,b FROM t;SELECT 1"},
+		{"-- users\nCREATE TABLE a (\n\tid INT -- key\n);\r\n", "CREATE TABLE a(id INT);"},
+		{"/* a /* nested */ comment */SELECT 1;", "SELECT 1;"},
+		{"SELECT 'a  -- b', \"c  d\";", "SELECT 'a  -- b',\"c  d\";"},
+		{"SELECT $$ two  spaces $$;", "SELECT $$ two  spaces $$;"},
+		{"SELECT E'it\\'s  /* */';", "SELECT E'it\\'s  /* */';"},
+		{"-- dsync:transactional=false\n-- note\nCREATE INDEX CONCURRENTLY i ON a (id);", "-- dsync:transactional=false\nCREATE INDEX CONCURRENTLY i ON a(id);"},
+	}
+	for _, test := range tests {
+		if normalized := dsync.NormalizeSQL(test.script); normalized != test.normalized {
+			t.Errorf("%q: expected %q, got %q", test.script, test.normalized, normalized)
+		}
+	}
+}
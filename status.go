@@ -0,0 +1,154 @@
+package dsync
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MigrationState State of a migration as reported by Migrator.Status
+type MigrationState int
+
+const (
+	// Applied The file has been applied and its checksum matches
+	Applied MigrationState = iota
+	// Pending The file has not been applied yet, or is a changed repeatable migration, and would be applied by the
+	// next run
+	Pending
+	// Missing The migration is recorded in the database but its file no longer exists
+	Missing
+	// ChecksumMismatch The file has been applied but modified since
+	ChecksumMismatch
+	// OutOfOrder The file has not been applied and its version is not above the current version. The next run
+	// fails on it unless Migrator.OutOfOrder is set (and its version differs from the current version).
+	OutOfOrder
+	// Incomplete The migration was applied outside of a transaction and interrupted before completing, Migrate
+	// refuses to run until it is resolved
+	Incomplete
+)
+
+func (s MigrationState) String() string {
+	switch s {
+	case Applied:
+		return "applied"
+	case Pending:
+		return "pending"
+	case Missing:
+		return "missing"
+	case ChecksumMismatch:
+		return "checksum mismatch"
+	case Incomplete:
+		return "incomplete"
+	case OutOfOrder:
+		return "out of order"
+	default:
+		return "unknown"
+	}
+}
+
+// MigrationStatus A migration file or migration table row, along with its state
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	File    string
+	// AppliedAt Zero unless the migration has been recorded in the database
+	AppliedAt time.Time
+	Checksum  int64
+	State     MigrationState
+	// Repeatable See Migration.Repeatable
+	Repeatable bool
+	// NoOp The migration was recorded without being executed, see Migration.NoOp
+	NoOp bool
+}
+
+// Status Report the state of every migration file in the change set directory and of every migration recorded in
+// the database, in ascending version order followed by the repeatable migrations. Nothing is applied and no transaction is started.
+func (migrator Migrator) Status(ds DataSource) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	info, err := readMigrationInfo(context.Background(), ds)
+	if err != nil {
+		return nil, err
+	}
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadDataSourceMigrations(cfs, ds, migrator.loadOptions())
+	if err != nil {
+		return nil, err
+	}
+	if err := digestApplied(cfs, ds.GetPath(), migrations, info.Migrations, migrator.loadOptions()); err != nil {
+		return nil, err
+	}
+
+	sortMigrations(info.Migrations)
+
+	for i := range migrations {
+		m := &migrations[i]
+		status := MigrationStatus{Version: m.Version, Name: m.Name, File: m.File, Checksum: m.Checksum, Repeatable: m.Repeatable}
+
+		e, dbm := migrator.verifyFsMigration(m, info.Migrations, info.Version)
+		if dbm != nil && !dbm.Success {
+			status.State = Incomplete
+			status.AppliedAt = dbm.CreatedAt
+			statuses = append(statuses, status)
+			continue
+		}
+		switch e {
+		case err_migration_valid:
+			status.State = Applied
+			status.AppliedAt = dbm.CreatedAt
+			status.NoOp = dbm.NoOp
+		case err_migration_checksum_mismatch:
+			status.State = ChecksumMismatch
+			status.AppliedAt = dbm.CreatedAt
+		case err_new_migration, err_new_out_of_order:
+			status.State = Pending
+		case err_repeatable_changed:
+			// applied again by the next run
+			status.State = Pending
+			status.AppliedAt = dbm.CreatedAt
+		case err_migration_conflict, err_migration_out_of_order:
+			status.State = OutOfOrder
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, dbm := range info.Migrations {
+		if hasFile(migrations, dbm.File) {
+			continue
+		}
+		statuses = append(statuses, MigrationStatus{
+			Version:    dbm.Version,
+			Name:       dbm.Name,
+			File:       dbm.File,
+			AppliedAt:  dbm.CreatedAt,
+			Checksum:   dbm.Checksum,
+			State:      Missing,
+			Repeatable: strings.HasPrefix(path.Base(dbm.File), repeatable_prefix),
+			NoOp:       dbm.NoOp,
+		})
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].Repeatable != statuses[j].Repeatable {
+			return !statuses[i].Repeatable
+		}
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+func hasFile(migrations []Migration, file string) bool {
+	for _, m := range migrations {
+		if sameFile(m.File, file) {
+			return true
+		}
+	}
+	return false
+}
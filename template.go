@@ -0,0 +1,32 @@
+package dsync
+
+import (
+	"bytes"
+	"io"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// RenderTemplate Render a migration script with text/template and the given data, before it is split and executed.
+// The script is returned as is when data is nil, so that it is still streamed. Checksums are computed on the
+// template, not on its output, so that a file verifies alike whatever the data.
+func RenderTemplate(name string, script io.Reader, data interface{}) (io.Reader, error) {
+	if data == nil {
+		return script, nil
+	}
+
+	content, err := io.ReadAll(script)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse migration template")
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, errors.Wrap(err, "failed to render migration template")
+	}
+	return &rendered, nil
+}
@@ -0,0 +1,44 @@
+package dsync
+
+import "context"
+
+// Span names passed to Tracer.StartSpan
+const (
+	// SpanMigrate The whole Migrate call, retries included
+	SpanMigrate = "dsync.migrate"
+	// SpanMigration A migration applied by a Migrate call, child of SpanMigrate
+	SpanMigration = "dsync.migration"
+)
+
+// Attribute A key and a string, int64 or bool value describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer Receives a span for every Migrate call and a child span for each migration it applies, e.g. to forward them
+// to OpenTelemetry (see the github.com/SharkFourSix/dsync/otel module). dsync itself depends on no tracing library.
+type Tracer interface {
+	// StartSpan Start a span, child of the span carried by ctx if any. The returned context carries the new span and
+	// is passed down to the data source; end is called once with the outcome, nil on success.
+	StartSpan(ctx context.Context, name string, attributes []Attribute) (spanCtx context.Context, end func(err error))
+}
+
+// startSpan Start a span with the migrator's Tracer, doing nothing without one
+func (migrator Migrator) startSpan(ctx context.Context, name string, attributes []Attribute) (context.Context, func(err error)) {
+	if migrator.Tracer == nil {
+		return ctx, func(error) {}
+	}
+	return migrator.Tracer.StartSpan(ctx, name, attributes)
+}
+
+// migrationAttributes Attributes of the span of a migration
+func migrationAttributes(m *Migration) []Attribute {
+	return []Attribute{
+		{Key: "dsync.version", Value: m.Version},
+		{Key: "dsync.name", Value: m.Name},
+		{Key: "dsync.file", Value: m.File},
+		{Key: "dsync.repeatable", Value: m.Repeatable},
+		{Key: "dsync.out_of_order", Value: m.OutOfOrder},
+	}
+}
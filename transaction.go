@@ -0,0 +1,62 @@
+package dsync
+
+import "context"
+
+// transaction Tracks the data source transaction during a migration run. The transaction is started
+// lazily, may be committed early when a migration has to run outside of it, and is rolled back when the
+// run fails before committing.
+type transaction struct {
+	ds     DataSource
+	active bool
+
+	// uncommitted migrations applied within the active transaction, committed migrations are durable
+	uncommitted []*Migration
+	committed   []*Migration
+}
+
+// applied Record a successfully applied migration
+func (t *transaction) applied(m *Migration) {
+	if t.active {
+		t.uncommitted = append(t.uncommitted, m)
+	} else {
+		t.committed = append(t.committed, m)
+	}
+}
+
+func (t *transaction) begin(ctx context.Context) error {
+	if t.active {
+		return nil
+	}
+	if err := beginTransaction(ctx, t.ds); err != nil {
+		return err
+	}
+	t.active = true
+	return nil
+}
+
+// commit Commit the active transaction. When the commit fails the uncommitted migrations are discarded, none of
+// them has been persisted.
+func (t *transaction) commit() error {
+	if !t.active {
+		return nil
+	}
+	t.ds.SetTransactionSuccessful(true)
+	err := t.ds.EndTransaction()
+	t.active = false
+	if err == nil {
+		t.committed = append(t.committed, t.uncommitted...)
+	}
+	t.uncommitted = nil
+	return err
+}
+
+func (t *transaction) rollback() {
+	if !t.active {
+		return
+	}
+	t.ds.SetTransactionSuccessful(false)
+	// the run already failed, a rollback error would only mask the original one
+	t.ds.EndTransaction()
+	t.active = false
+	t.uncommitted = nil
+}
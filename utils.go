@@ -1,16 +1,20 @@
 package dsync
 
 import (
+	"bufio"
 	"hash/crc32"
 	"io"
 	"io/fs"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/pkg/errors"
 )
 
+const repeatable_prefix = "R__"
+
 type state int
 
 const (
@@ -19,17 +23,49 @@ const (
 	state_read_separators
 )
 
+// migration_separator Separator between the version and the name of a migration file
+const migration_separator = "__"
+
 type parser_error struct {
 	pos      int
 	filename string
+	reason   string
 }
 
 func (pe parser_error) Error() string {
+	if pe.reason != "" {
+		return pe.filename + ": " + pe.reason
+	}
 	return pe.filename + ": invalid character in migration file name at " + strconv.FormatInt(int64(pe.pos), 10)
 }
 
-// ParseMigration Parse migration information from file name
+const separator_reason = "the version must be followed by exactly two underscores (" + migration_separator + ")"
+
+// ParseMigration Parse migration information from file name. This is the only parser of migration file names, the
+// version it returns is also the one LoadMigrations sorts by. The grammar is
+//
+//	versioned  = digits "__" name      e.g. 0001__init.sql, 202401150930__add_users.sql
+//	repeatable = "R__" name            e.g. R__views.sql
+//
+// where the separator is exactly two underscores and name is not empty and does not start with an underscore
+// (0001_init.sql and 0001___init.sql are rejected). The name keeps the file extension, except for the .gz suffix
+// of compressed files (0001__init.sql.gz is named init.sql).
 func ParseMigration(filename string) (*Migration, error) {
+	return parseMigration(filename, false)
+}
+
+// parseMigration ParseMigration, also accepting dotted versions (1.2.10__init.sql) when semantic is set, see
+// SemanticVersion
+func parseMigration(filename string, semantic bool) (*Migration, error) {
+	if err := checkFilename(filename); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(filename, repeatable_prefix) {
+		if len(trimCompressed(filename)) == len(repeatable_prefix) {
+			return nil, parser_error{pos: len(repeatable_prefix), filename: filename}
+		}
+		return &Migration{File: filename, Name: trimCompressed(filename)[len(repeatable_prefix):], Repeatable: true}, nil
+	}
 
 	var pos = 0
 	var migration Migration
@@ -46,10 +82,13 @@ func ParseMigration(filename string) (*Migration, error) {
 				switch _state {
 				case state_read_name:
 					migration.File = filename
-					migration.Name = builder.String()
+					migration.Name = trimCompressed(builder.String())
 					return &migration, nil
 				case state_read_separators:
-					fallthrough
+					if separators_count < len(migration_separator) {
+						return nil, parser_error{pos: pos, filename: filename, reason: separator_reason}
+					}
+					return nil, parser_error{pos: pos, filename: filename, reason: "missing migration name after the version"}
 				case state_read_version:
 					return nil, parser_error{pos: pos, filename: filename}
 				}
@@ -59,28 +98,29 @@ func ParseMigration(filename string) (*Migration, error) {
 		}
 		switch _state {
 		case state_read_version:
-			if !unicode.IsDigit(r) {
-				_version, err := strconv.ParseInt(builder.String(), 10, 64)
+			if !unicode.IsDigit(r) && !(semantic && r == '.') {
+				_version, label, err := parseVersion(builder.String(), semantic)
 				if err != nil {
 					return nil, errors.Wrapf(err, "%s:%d error parsing migration file name", filename, pos)
 				}
 				_state = state_read_separators
 				reader.UnreadRune()
 				migration.Version = _version
+				migration.VersionLabel = label
 				builder.Reset()
 			} else {
 				builder.WriteRune(r)
 			}
 		case state_read_separators:
 			if r != '_' {
-				if separators_count == 2 {
+				if separators_count == len(migration_separator) {
 					_state = state_read_name
 					reader.UnreadRune()
 				} else {
-					return nil, parser_error{pos: pos, filename: filename}
+					return nil, parser_error{pos: pos, filename: filename, reason: separator_reason}
 				}
-			} else if separators_count > 2 {
-				return nil, parser_error{pos: pos, filename: filename}
+			} else if separators_count == len(migration_separator) {
+				return nil, parser_error{pos: pos, filename: filename, reason: separator_reason}
 			} else {
 				separators_count++
 			}
@@ -91,27 +131,116 @@ func ParseMigration(filename string) (*Migration, error) {
 	}
 }
 
-// HashFile Calculate file content checksum using CRC32(IEEE)
-func HashFile(_fs fs.FS, filename string) (int64, error) {
-	var buf []byte
-	var h = crc32.New(crc32.MakeTable(crc32.IEEE))
+// checkFilename Reject file names that are not a single path element (separators, "..", NUL bytes), so that a
+// parsed name joined to the base path cannot point outside of it
+func checkFilename(filename string) error {
+	if i := strings.IndexAny(filename, "/\\\x00"); i >= 0 {
+		return parser_error{pos: i, filename: filename, reason: "file names must not contain path separators or NUL bytes"}
+	}
+	if filename == "." || filename == ".." {
+		return parser_error{filename: filename, reason: "not a file name"}
+	}
+	return nil
+}
 
-	buf = make([]byte, 1024)
+// versionLabel Format timestamp versions (yyyyMMddHHmm or yyyyMMddHHmmss) as a date, other versions are kept as
+// written in the file name
+func versionLabel(version string) string {
+	var layout, label string
+
+	switch len(version) {
+	case 12:
+		layout, label = "200601021504", "2006-01-02 15:04"
+	case 14:
+		layout, label = "20060102150405", "2006-01-02 15:04:05"
+	default:
+		return version
+	}
+	t, err := time.Parse(layout, version)
+	if err != nil {
+		return version
+	}
+	return t.Format(label)
+}
 
-	file, err := _fs.Open(filename)
+// HashFile Calculate file content checksum using CRC32(IEEE). Compressed (.gz) files are hashed decompressed, so
+// that compressing a migration file does not change its checksum. The file is read through _fs, like the data
+// sources read it to execute it.
+func HashFile(_fs fs.FS, filename string) (int64, error) {
+	file, err := OpenMigrationFile(_fs, filename)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to calculate file hash")
 	}
 	defer file.Close()
 
-	for {
-		r, err := file.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				return int64(h.Sum32()), nil
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, file); err != nil {
+		return 0, errors.Wrap(err, "failed to calculate file hash")
+	}
+	return int64(h.Sum32()), nil
+}
+
+const directive_prefix = "dsync:"
+
+// readDirectives Read the "-- dsync:key=value" directives declared in the leading comment block of a migration
+// file. Reading stops at the first line that is neither blank nor a comment.
+func readDirectives(_fs fs.FS, filename string, m *Migration) error {
+	file, err := OpenMigrationFile(_fs, filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to read migration directives")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(line, directive_prefix) {
+			continue
+		}
+		key, value := splitDirective(strings.TrimPrefix(line, directive_prefix))
+		switch key {
+		case "transactional":
+			transactional, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.Errorf("%s: invalid value %q for directive %s", m.File, value, key)
+			}
+			m.NoTransaction = !transactional
+		case "no-transaction":
+			m.NoTransaction = true
+		case "when":
+			if value == "" {
+				return errors.Errorf("%s: missing query for directive %s", m.File, key)
 			}
-			return 0, err
+			m.Condition = value
+		case "require":
+			requirement, err := ParseServerRequirement(value)
+			if err != nil {
+				return errors.Errorf("%s: %s", m.File, err)
+			}
+			m.Requirement = &requirement
+		case "blob":
+			blob, err := parseBlob(m, value)
+			if err != nil {
+				return err
+			}
+			m.Blobs = append(m.Blobs, blob)
+		default:
+			return errors.Errorf("%s: unknown directive %s", m.File, key)
 		}
-		h.Write(buf[:r])
 	}
+	return errors.Wrap(scanner.Err(), "failed to read migration directives")
+}
+
+func splitDirective(s string) (string, string) {
+	if i := strings.IndexAny(s, "= \t"); i >= 0 {
+		return strings.ToLower(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	return strings.ToLower(s), ""
 }
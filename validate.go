@@ -0,0 +1,163 @@
+package dsync
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidationError Every problem found by Migrator.Validate
+type ValidationError struct {
+	Errors []error
+}
+
+func (e ValidationError) Error() string {
+	var builder strings.Builder
+
+	builder.WriteString(strconv.Itoa(len(e.Errors)))
+	builder.WriteString(" migration(s) failed validation")
+	for _, err := range e.Errors {
+		builder.WriteString("; ")
+		builder.WriteString(err.Error())
+	}
+	return builder.String()
+}
+
+// Validate Verify the change set directory against the migration table without applying anything, for instance as
+// a CI check. Every problem is reported in a single ValidationError: unparseable or unreadable files, applied files
+// that have been modified, versions that are already applied or behind the current version (unless OutOfOrder is
+// set), migrations recorded in the database whose file no longer exists (unless AllowMissingFiles is set) and
+// interrupted non-transactional migrations. Version windows and conditions are not taken into account. No
+// transaction is started and nothing is written, the migration table is neither created nor upgraded.
+func (migrator Migrator) Validate(ds DataSource) error {
+	return migrator.validate(context.Background(), ds)
+}
+
+// validate Validate using the context of the run
+func (migrator Migrator) validate(ctx context.Context, ds DataSource) error {
+	var problems []error
+
+	info, err := readMigrationInfo(ctx, ds)
+	if err != nil {
+		return err
+	}
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadDataSourceMigrations(cfs, ds, migrator.loadOptions())
+	loaded := err == nil
+	if err != nil {
+		var loadError LoadError
+		if !errors.As(err, &loadError) {
+			return err
+		}
+		problems = append(problems, loadError.Errors...)
+	}
+	if err := digestApplied(cfs, ds.GetPath(), migrations, info.Migrations, migrator.loadOptions()); err != nil {
+		problems = append(problems, err)
+	}
+
+	sortMigrations(info.Migrations)
+
+	for i := range migrations {
+		m := &migrations[i]
+		e, dbm := migrator.verifyFsMigration(m, info.Migrations, info.Version)
+		if dbm != nil && !dbm.Success {
+			// reported below
+			continue
+		}
+		if err := verificationFailure(e, m, dbm, info.Version); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	for _, dbm := range info.Migrations {
+		if !dbm.Success {
+			problems = append(problems, errors.Errorf("%s: migration applied outside of a transaction was interrupted", dbm.File))
+		}
+		// files of an unparseable directory are unknown, do not report them as missing as well
+		if loaded && !migrator.AllowMissingFiles && !hasFile(migrations, dbm.File) {
+			problems = append(problems, errors.Errorf("%s: migration version %d is applied but its file is missing", dbm.File, dbm.Version))
+		}
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{Errors: problems}
+	}
+	return nil
+}
+
+// VerifyApplied Hash the file of every applied migration again and compare it with its recorded checksum, ignoring
+// pending files entirely, e.g. on a schedule against production to detect edits of the deployed change set. Each
+// file is hashed with the algorithm it was recorded with. Modified files (as ChecksumMismatchError) and missing files
+// (unless AllowMissingFiles is set) are reported in a single ValidationError. Repeatable migrations are skipped,
+// since changing them is how they are applied again. No transaction is started.
+func (migrator Migrator) VerifyApplied(ds DataSource) error {
+	var problems []error
+
+	info, err := readMigrationInfo(context.Background(), ds)
+	if err != nil {
+		return err
+	}
+
+	cfs, err := ds.GetChangeSetFileSystem()
+	if err != nil {
+		return err
+	}
+
+	for i := range info.Migrations {
+		dbm := &info.Migrations[i]
+		if strings.HasPrefix(path.Base(dbm.File), repeatable_prefix) {
+			continue
+		}
+		err := migrator.verifyAppliedFile(cfs, ds.GetPath(), dbm)
+		if errors.Is(err, fs.ErrNotExist) {
+			if !migrator.AllowMissingFiles {
+				problems = append(problems, errors.Errorf("%s: migration version %d is applied but its file is missing", dbm.File, dbm.Version))
+			}
+			continue
+		}
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{Errors: problems}
+	}
+	return nil
+}
+
+// verifyAppliedFile Compare the checksum of the file of an applied migration with the recorded one
+func (migrator Migrator) verifyAppliedFile(cfs fs.FS, basepath string, dbm *Migration) error {
+	filename := filepath.Join(basepath, dbm.File)
+	m := Migration{File: dbm.File}
+	if err := readDirectives(cfs, filename, &m); err != nil {
+		return err
+	}
+
+	opts := migrator.loadOptions()
+	opts.ChecksumAlgorithm = recordedAlgorithm(dbm)
+	checksum, digest, size, err := checksums(cfs, filename, blobPaths(basepath, &m), opts)
+	if err != nil {
+		return errors.Wrap(err, dbm.File)
+	}
+	m.Checksum, m.Digest, m.Size = checksum, digest, size
+	if checksumMatches(&m, dbm) {
+		return nil
+	}
+
+	mismatch := ChecksumMismatchError{File: dbm.File, Expected: dbm.Checksum, Actual: m.Checksum, ExpectedSize: dbm.Size, ActualSize: m.Size}
+	if m.Digest != "" && dbm.Digest != "" {
+		mismatch.ExpectedDigest, mismatch.ActualDigest = dbm.Digest, m.Digest
+	}
+	return mismatch
+}